@@ -6,12 +6,18 @@ import (
 
 	h "github.com/SaidovZohid/swiftsend.it/api/handlers"
 	"github.com/SaidovZohid/swiftsend.it/config"
+	"github.com/SaidovZohid/swiftsend.it/pkg/blob"
+	"github.com/SaidovZohid/swiftsend.it/pkg/hostkeys"
+	"github.com/SaidovZohid/swiftsend.it/pkg/links"
 	"github.com/SaidovZohid/swiftsend.it/pkg/logger"
+	"github.com/SaidovZohid/swiftsend.it/pkg/quota"
+	"github.com/SaidovZohid/swiftsend.it/pkg/ratelimit"
 	"github.com/SaidovZohid/swiftsend.it/sshserver"
 	"github.com/SaidovZohid/swiftsend.it/storage"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/template/django/v3"
 
+	"github.com/gofiber/fiber/v2/middleware/expvar"
 	"github.com/gofiber/fiber/v2/middleware/favicon"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 )
@@ -21,7 +27,16 @@ type RoutetOptions struct {
 	Log    logger.Logger
 	Engine *django.Engine
 	Strg   storage.StorageI
-	Pipes  map[string]sshserver.Tunnel
+	Pipes  *sshserver.Tunnels
+	Links  *links.Builder
+	// Limiter limits requests per ip address, nil allows everything
+	Limiter *ratelimit.Limiter
+	// Quota limits uploads of senders, nil is unlimited
+	Quota *quota.Quota
+	// Blobs keeps quarantined files encrypted, nil when they are not kept
+	Blobs *blob.Store
+	// HostKeys of the ssh server, their fingerprints are shown on how to use page
+	HostKeys *hostkeys.Keys
 }
 
 func New(opt *RoutetOptions) *fiber.App {
@@ -31,6 +46,11 @@ func New(opt *RoutetOptions) *fiber.App {
 	app := fiber.New(fiber.Config{
 		Views:        engine,
 		WriteTimeout: 10 * time.Minute,
+		// behind a proxy the client ip comes from X-Forwarded-For set by the proxy
+		EnableTrustedProxyCheck: len(opt.Cfg.TrustedProxies) > 0,
+		TrustedProxies:          opt.Cfg.TrustedProxies,
+		ProxyHeader:             proxyHeader(opt.Cfg),
+		EnableIPValidation:      true,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			opt.Log.Error(err)
 			return c.Render("errors/500", fiber.Map{
@@ -53,32 +73,50 @@ func New(opt *RoutetOptions) *fiber.App {
 	app.Static("/assets", "./www/assets")
 
 	handlers := h.New(&h.HandlerV1Options{
-		Cfg:   opt.Cfg,
-		Log:   opt.Log,
-		Strg:  opt.Strg,
-		Pipes: opt.Pipes,
+		Cfg:      opt.Cfg,
+		Log:      opt.Log,
+		Strg:     opt.Strg,
+		Pipes:    opt.Pipes,
+		Links:    opt.Links,
+		Limiter:  opt.Limiter,
+		Quota:    opt.Quota,
+		Blobs:    opt.Blobs,
+		HostKeys: opt.HostKeys,
 	})
 
+	if opt.Cfg.Github.KeySyncInterval > 0 {
+		go handlers.SyncGithubKeysEvery(opt.Cfg.Github.KeySyncInterval)
+	}
+
+	// <subdomain>., direct. and delete. hostnames
+	app.Use(handlers.HostRouter)
+
 	app.Get("/", handlers.HandleLandingPage)
 	app.Get("/terms", handlers.HandleTermsPage)
 	app.Get("/how-to-use", handlers.HandleHowToUsePage)
-	app.Get("/login", handlers.HandleLoginPage)         // login page
-	app.Get("/signup", handlers.HandleSignUpPage)       // signup page
-	app.Get("/logout", handlers.HandleLogout)           // logout api
-	app.Get("/login/github", handlers.HandleGithubAuth) // handler github login
-	// app.Get("/login/google", handlers.HandleGoogleAuth)                  // handler google login
-	app.Get("/login/github/callback", handlers.HandleGithubAuthCallback) // handler github login callback
-	// app.Get("/login/google/callback", handlers.HandleGoogleCallback)     // handler github login callback
+	app.Get("/login", handlers.HandleLoginPage)   // login page
+	app.Get("/signup", handlers.HandleSignUpPage) // signup page
+	app.Get("/logout", handlers.HandleLogout)     // logout api
+
+	// sign in with github, google or oidc provider and its callback
+	app.Get("/login/:provider", handlers.RateLimit(ratelimit.Login), handlers.HandleProviderLogin)
+	app.Get("/login/:provider/callback", handlers.RateLimit(ratelimit.Login), handlers.HandleProviderCallback)
 
 	// get subdomain info
 	app.Get("/domain/:subdomain", handlers.HandleGetSubdomainInfo)
 
 	// download apis
 	app.Get("/download/:subdomain/:link", handlers.HandleDownloadPaage)
-	app.Get("/direct/:link", handlers.HandleDirectDownload)
+	app.Get("/direct/:link.sha256", handlers.RateLimit(ratelimit.Direct), handlers.HandleDirectChecksum)
+	app.Get("/direct/:link", handlers.RateLimit(ratelimit.Direct), handlers.HandleDirectDownload)
+	app.Post("/direct/:link", handlers.RateLimit(ratelimit.Direct), handlers.HandleDirectDownload)
+	app.Get("/events/:link", handlers.HandleLinkEvents)
+	app.Get("/preview/:link", handlers.HandlePreview)
+	app.Get("/qr/:link.png", handlers.HandleQRCode)
 
 	// delete sent file uri
-	app.Get("/delete/:link", handlers.HandleDeleteSentFile)
+	app.Get("/delete/:link", handlers.RateLimit(ratelimit.Delete), handlers.HandleDeleteSentFile)
+	app.Post("/report/:link", handlers.RateLimit(ratelimit.Report), handlers.HandleReportLink)
 
 	// error api for checking error page
 	app.Get("/error", func(c *fiber.Ctx) error {
@@ -89,14 +127,68 @@ func New(opt *RoutetOptions) *fiber.App {
 	must := app.Group("/s", handlers.AuthMiddleware)
 	must.Get("/settings/account", handlers.HandleSettingGetAccount)
 	must.Post("/settings/create/account", handlers.HandleSettingPostAccount)
+	must.Post("/settings/subdomain/rename", handlers.HandleSettingRenameSubdomain)
+	must.Post("/settings/subdomain/release", handlers.HandleSettingReleaseSubdomain)
 	must.Get("/settings/keys", handlers.HandleSettingGetKeys)
 	must.Post("/settings/keys/d/:id", handlers.HandleDeleteKey)
 	must.Get("/settings/keys/add", handlers.HandleSettingAddKeyPage)
 	must.Post("/settings/keys/add", handlers.HandleSettingAddKey)
+	must.Post("/settings/keys/sync", handlers.HandleSettingSyncGithubKeys)
+	must.Get("/settings/keys/e/:id", handlers.HandleSettingEditKeyPage)
+	must.Post("/settings/keys/e/:id", handlers.HandleSettingEditKey)
+	must.Get("/settings/domain", handlers.HandleSettingGetDomain)
+	must.Post("/settings/domain", handlers.HandleSettingPostDomain)
+	must.Post("/settings/domain/verify", handlers.HandleSettingVerifyDomain)
+	must.Post("/settings/domain/d", handlers.HandleSettingDeleteDomain)
+	must.Get("/settings/sessions", handlers.HandleSettingGetSessions)
+	must.Post("/settings/sessions/d/:id", handlers.HandleSettingDeleteSession)
+	must.Post("/settings/sessions/others", handlers.HandleSettingDeleteOtherSessions)
+	must.Get("/settings/logins", handlers.HandleSettingGetLogins)
+	must.Post("/settings/logins/link/:provider", handlers.HandleSettingLinkLogin)
+	must.Post("/settings/logins/d/:id", handlers.HandleSettingUnlinkLogin)
+	must.Get("/settings/danger", handlers.HandleSettingGetDanger)
+	must.Post("/settings/danger/export", handlers.HandleSettingExport)
+	must.Post("/settings/danger/delete/:provider", handlers.HandleSettingDeleteAccount)
+
+	// upload counters of the ssh server, only for admins
+	app.Get("/debug/vars", handlers.AdminMiddleware, expvar.New())
+
+	admin := app.Group("/admin", handlers.AdminMiddleware)
+	admin.Get("/", func(c *fiber.Ctx) error {
+		return c.Redirect(c.BaseURL() + "/admin/users")
+	})
+	admin.Get("/users", handlers.HandleAdminUsers)
+	admin.Post("/users/:id/plan", handlers.HandleAdminSetPlan)
+	admin.Post("/keys/d/:id", handlers.HandleAdminDeleteKey)
+	admin.Get("/sessions", handlers.HandleAdminSessions)
+	admin.Post("/sessions/d/:id", handlers.HandleAdminRevokeSession)
+	admin.Get("/tunnels", handlers.HandleAdminTunnels)
+	admin.Post("/tunnels/k/:link", handlers.HandleAdminKillTunnel)
+	admin.Get("/transfers", handlers.HandleAdminTransfers)
+	admin.Get("/bans", handlers.HandleAdminBans)
+	admin.Post("/bans", handlers.HandleAdminAddBan)
+	admin.Post("/bans/d/:id", handlers.HandleAdminDeleteBan)
+	admin.Get("/quarantine/:name", handlers.HandleAdminQuarantinedFile)
+	admin.Get("/reports", handlers.HandleAdminReports)
+	admin.Post("/reports/:id/restore", handlers.HandleAdminRestoreReport)
+	admin.Post("/reports/:id/delete", handlers.HandleAdminDeleteReport)
+	admin.Post("/reports/:id/ban/:kind", handlers.HandleAdminBanReport)
+	admin.Get("/reserved", handlers.HandleAdminReserved)
+	admin.Post("/reserved", handlers.HandleAdminAddReserved)
+	admin.Post("/reserved/d/:name", handlers.HandleAdminDeleteReserved)
+	admin.Get("/audit", handlers.HandleAdminAudit)
 
 	app.Use(func(c *fiber.Ctx) error {
 		return c.Redirect("/", fiber.StatusFound)
 	})
 
 	return app
+}
+
+func proxyHeader(cfg *config.Config) string {
+	if len(cfg.TrustedProxies) == 0 {
+		return ""
+	}
+
+	return fiber.HeaderXForwardedFor
 }
\ No newline at end of file
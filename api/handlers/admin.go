@@ -0,0 +1,577 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/blob"
+	"github.com/SaidovZohid/swiftsend.it/sshserver"
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const adminPageSize int64 = 20
+
+type adminPage struct {
+	Search string
+	Page   int64
+	Pages  int64
+	Total  int64
+	Prev   int64 // zero when there is no previous page
+	Next   int64 // zero when there is no next page
+}
+
+type adminTunnel struct {
+	Link        string
+	Subdomain   string
+	IPAddress   string
+	Fingerprint string
+	Size        int64
+	SentAt      time.Time
+	ExpiresAt   time.Time
+}
+
+// AdminMiddleware lets only users with admin role in, others see not found page
+func (h *handlerV1) AdminMiddleware(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+	if data == nil {
+		return c.Redirect(c.BaseURL() + "/login")
+	}
+
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		h.log.Error(err)
+		return err
+	}
+	if user == nil || user.Role != mongodb.RoleAdmin {
+		return c.Status(fiber.StatusNotFound).Render("errors/404", fiber.Map{
+			"what": "Page",
+			"link": h.cfg.BaseURL,
+			"text": "The page you are looking for doesn't exist. 🚫",
+		})
+	}
+
+	c.Locals("admin", user.Username)
+	return c.Next()
+}
+
+// adminQuery reads search and page number of list pages
+func adminQuery(c *fiber.Ctx) (string, int64) {
+	page := int64(c.QueryInt("page", 1))
+	if page < 1 {
+		page = 1
+	}
+
+	return strings.TrimSpace(c.Query("q")), page
+}
+
+func newAdminPage(search string, page, total int64) adminPage {
+	p := adminPage{
+		Search: search,
+		Page:   page,
+		Pages:  (total + adminPageSize - 1) / adminPageSize,
+		Total:  total,
+	}
+	if p.Pages == 0 {
+		p.Pages = 1
+	}
+	if page > 1 {
+		p.Prev = page - 1
+	}
+	if page < p.Pages {
+		p.Next = page + 1
+	}
+
+	return p
+}
+
+func (h *handlerV1) renderAdmin(c *fiber.Ctx, name string, data fiber.Map) error {
+	data["username"] = c.Locals("admin")
+	data["links"] = UserVerifiedHeader
+	data["section"] = name
+
+	return c.Render("admin/"+name, data)
+}
+
+func (h *handlerV1) HandleAdminUsers(c *fiber.Ctx) error {
+	search, page := adminQuery(c)
+	users, total, err := h.strg.User().GetUsers(context.Background(), search, page, adminPageSize)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return h.renderAdmin(c, "users", fiber.Map{
+		"users": users,
+		"plans": h.quotas.Plans(),
+		"page":  newAdminPage(search, page, total),
+	})
+}
+
+func (h *handlerV1) HandleAdminSessions(c *fiber.Ctx) error {
+	search, page := adminQuery(c)
+	sessions, total, err := h.strg.Session().GetSessions(context.Background(), search, page, adminPageSize)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return h.renderAdmin(c, "sessions", fiber.Map{
+		"sessions": sessions,
+		"page":     newAdminPage(search, page, total),
+	})
+}
+
+func (h *handlerV1) HandleAdminTunnels(c *fiber.Ctx) error {
+	search, page := adminQuery(c)
+
+	live := h.pipes.Snapshot()
+	tunnels := make([]adminTunnel, 0, len(live))
+	for link, val := range live {
+		t := adminTunnel{
+			Link:      link,
+			Size:      val.File.FileSize,
+			SentAt:    val.SentAt,
+			ExpiresAt: val.ExpiresAt,
+		}
+		if val.User != nil {
+			t.Subdomain = val.User.Subdomain
+			t.IPAddress = val.User.IPAddress
+			t.Fingerprint = val.User.Fingerprint
+		}
+		if search != "" && search != t.Link && search != t.Subdomain && search != t.Fingerprint && !strings.HasPrefix(t.IPAddress, search) {
+			continue
+		}
+		tunnels = append(tunnels, t)
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].SentAt.After(tunnels[j].SentAt)
+	})
+
+	total := int64(len(tunnels))
+	from := (page - 1) * adminPageSize
+	if from > total {
+		from = total
+	}
+	to := from + adminPageSize
+	if to > total {
+		to = total
+	}
+
+	return h.renderAdmin(c, "tunnels", fiber.Map{
+		"tunnels": tunnels[from:to],
+		"page":    newAdminPage(search, page, total),
+	})
+}
+
+func (h *handlerV1) HandleAdminTransfers(c *fiber.Ctx) error {
+	search, page := adminQuery(c)
+	transfers, total, err := h.strg.Transfer().GetTransfers(context.Background(), search, page, adminPageSize)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return h.renderAdmin(c, "transfers", fiber.Map{
+		"transfers": transfers,
+		"page":      newAdminPage(search, page, total),
+	})
+}
+
+// HandleAdminQuarantinedFile sends the decrypted quarantined file, ranges are decrypted chunk by chunk
+func (h *handlerV1) HandleAdminQuarantinedFile(c *fiber.Ctx) error {
+	if h.blobs == nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	name := c.Params("name")
+	f, err := h.blobs.Open(name)
+	if errors.Is(err, blob.ErrName) || errors.Is(err, fs.ErrNotExist) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+name+`.quarantined"`)
+	return sendRange(c, f, f, f.Size())
+}
+
+type sectionCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// sendRange sends the file or the single byte range the client asked for, several ranges get the whole file.
+// The file is closed once it is sent.
+func sendRange(c *fiber.Ctx, r io.ReaderAt, closer io.Closer, size int64) error {
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	start, end := int64(0), size-1
+	if c.Get(fiber.HeaderRange) != "" {
+		rng, err := c.Range(int(size))
+		if errors.Is(err, fiber.ErrRangeUnsatisfiable) {
+			closer.Close()
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+			return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+		}
+		if err == nil && rng.Type == "bytes" && len(rng.Ranges) == 1 {
+			start, end = int64(rng.Ranges[0].Start), int64(rng.Ranges[0].End)
+			c.Status(fiber.StatusPartialContent)
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		}
+	}
+
+	return c.SendStream(sectionCloser{io.NewSectionReader(r, start, end-start+1), closer}, int(end-start+1))
+}
+
+func (h *handlerV1) HandleAdminBans(c *fiber.Ctx) error {
+	search, page := adminQuery(c)
+	bans, total, err := h.strg.Ban().GetBans(context.Background(), search, page, adminPageSize)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return h.renderAdmin(c, "bans", fiber.Map{
+		"bans": bans,
+		"kinds": []string{
+			mongodb.BanIP,
+			mongodb.BanFingerprint,
+			mongodb.BanSubdomain,
+		},
+		"page": newAdminPage(search, page, total),
+	})
+}
+
+func (h *handlerV1) HandleAdminReserved(c *fiber.Ctx) error {
+	search, page := adminQuery(c)
+	reserved, total, err := h.strg.Subdomain().GetReserved(context.Background(), search, page, adminPageSize)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return h.renderAdmin(c, "reserved", fiber.Map{
+		"reserved": reserved,
+		"page":     newAdminPage(search, page, total),
+	})
+}
+
+func (h *handlerV1) HandleAdminAudit(c *fiber.Ctx) error {
+	search, page := adminQuery(c)
+	events, total, err := h.strg.Audit().GetEvents(context.Background(), search, page, adminPageSize)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return h.renderAdmin(c, "audit", fiber.Map{
+		"events": events,
+		"page":   newAdminPage(search, page, total),
+	})
+}
+
+func (h *handlerV1) HandleAdminRevokeSession(c *fiber.Ctx) error {
+	if err := h.strg.Session().DeleteSessionByID(context.Background(), c.Params("id")); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/admin/sessions")
+}
+
+func (h *handlerV1) HandleAdminDeleteKey(c *fiber.Ctx) error {
+	if err := h.strg.User().DeleteKey(context.Background(), c.Params("id")); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/admin/users")
+}
+
+// HandleAdminSetPlan assigns a quota plan to the user, empty plan returns the user to the default one
+func (h *handlerV1) HandleAdminSetPlan(c *fiber.Ctx) error {
+	plan := c.FormValue("plan")
+	known := plan == ""
+	for _, name := range h.quotas.Plans() {
+		known = known || name == plan
+	}
+	if !known {
+		return c.Redirect(c.BaseURL() + "/admin/users")
+	}
+
+	user, err := h.strg.User().FindUserByID(context.Background(), c.Params("id"))
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	if err := h.strg.User().SetPlan(context.Background(), c.Params("id"), plan); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	if plan == "" {
+		plan = "default"
+	}
+	err = h.strg.Audit().AddEvent(context.Background(), &mongodb.AuditEvent{
+		Action:    mongodb.AuditPlanChanged,
+		UserID:    user.Id.Hex(),
+		Username:  user.Username,
+		Actor:     c.Locals("admin").(string),
+		IPAddress: c.IP(),
+		Details:   "plan " + plan,
+	})
+	if err != nil {
+		h.log.Error(err)
+	}
+
+	return c.Redirect(c.BaseURL() + "/admin/users?q=" + url.QueryEscape(user.Username))
+}
+
+func (h *handlerV1) HandleAdminKillTunnel(c *fiber.Ctx) error {
+	h.killTunnels(func(link string, _ sshserver.Tunnel) bool {
+		return link == c.Params("link")
+	})
+
+	return c.Redirect(c.BaseURL() + "/admin/tunnels")
+}
+
+func (h *handlerV1) HandleAdminAddBan(c *fiber.Ctx) error {
+	payload := struct {
+		Kind   string `json:"kind"`
+		Value  string `json:"value"`
+		Reason string `json:"reason"`
+	}{}
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	if err := h.banSender(c, payload.Kind, payload.Value, payload.Reason); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/admin/bans")
+}
+
+// banSender bans the ip address, fingerprint or subdomain and stops its transfers, unknown kinds and empty
+// values are ignored
+func (h *handlerV1) banSender(c *fiber.Ctx, kind, value, reason string) error {
+	value = strings.TrimSpace(value)
+	switch kind {
+	case mongodb.BanIP, mongodb.BanFingerprint:
+	case mongodb.BanSubdomain:
+		value = strings.ToLower(value)
+	default:
+		return nil
+	}
+	if value == "" {
+		return nil
+	}
+
+	err := h.strg.Ban().AddBan(context.Background(), &mongodb.Ban{
+		Kind:      kind,
+		Value:     value,
+		Reason:    strings.TrimSpace(reason),
+		CreatedBy: c.Locals("admin").(string),
+	})
+	if err != nil {
+		return err
+	}
+
+	// files which are being sent by the banned sender stop right away
+	h.killTunnels(func(_ string, val sshserver.Tunnel) bool {
+		if val.User == nil {
+			return false
+		}
+		switch kind {
+		case mongodb.BanIP:
+			return val.User.IPAddress == value
+		case mongodb.BanFingerprint:
+			return val.User.Fingerprint == value
+		default:
+			return val.User.Subdomain == value
+		}
+	})
+
+	return nil
+}
+
+// adminReport is a report in the moderation queue, Live tells if the link can still be restored
+type adminReport struct {
+	mongodb.Report
+	Live bool
+}
+
+func (h *handlerV1) HandleAdminReports(c *fiber.Ctx) error {
+	search, page := adminQuery(c)
+	status := c.Query("status", mongodb.ReportOpen)
+	reports, total, err := h.strg.Report().GetReports(context.Background(), status, search, page, adminPageSize)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	views := make([]adminReport, 0, len(reports))
+	for _, r := range reports {
+		_, live := h.pipes.Get(r.Link)
+		views = append(views, adminReport{Report: r, Live: live})
+	}
+
+	return h.renderAdmin(c, "reports", fiber.Map{
+		"reports":  views,
+		"status":   status,
+		"statuses": []string{mongodb.ReportOpen, mongodb.ReportRestored, mongodb.ReportDeleted, mongodb.ReportBanned},
+		"page":     newAdminPage(search, page, total),
+	})
+}
+
+// HandleAdminRestoreReport unfreezes the reported link and closes its reports
+func (h *handlerV1) HandleAdminRestoreReport(c *fiber.Ctx) error {
+	report, err := h.strg.Report().FindReport(context.Background(), c.Params("id"))
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	h.pipes.Update(report.Link, func(t *sshserver.Tunnel) { t.Frozen = false })
+
+	return h.resolveReports(c, report, mongodb.ReportRestored)
+}
+
+// HandleAdminDeleteReport takes the reported link down
+func (h *handlerV1) HandleAdminDeleteReport(c *fiber.Ctx) error {
+	report, err := h.strg.Report().FindReport(context.Background(), c.Params("id"))
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	h.killTunnels(func(link string, _ sshserver.Tunnel) bool {
+		return link == report.Link
+	})
+
+	return h.resolveReports(c, report, mongodb.ReportDeleted)
+}
+
+// HandleAdminBanReport bans fingerprint, ip address or subdomain of the sender of the reported link,
+// transfers of the sender including the reported one are stopped
+func (h *handlerV1) HandleAdminBanReport(c *fiber.Ctx) error {
+	report, err := h.strg.Report().FindReport(context.Background(), c.Params("id"))
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	kind := c.Params("kind")
+	values := map[string]string{
+		mongodb.BanIP:          report.IPAddress,
+		mongodb.BanFingerprint: report.Fingerprint,
+		mongodb.BanSubdomain:   report.Subdomain,
+	}
+	if values[kind] == "" {
+		return c.Redirect(c.BaseURL() + "/admin/reports")
+	}
+	if err := h.banSender(c, kind, values[kind], "abuse report of "+report.Link+": "+report.Reason); err != nil {
+		h.log.Error(err)
+		return err
+	}
+	h.killTunnels(func(link string, _ sshserver.Tunnel) bool {
+		return link == report.Link
+	})
+
+	return h.resolveReports(c, report, mongodb.ReportBanned)
+}
+
+// resolveReports closes all open reports of the link, a link is often reported several times
+func (h *handlerV1) resolveReports(c *fiber.Ctx, report *mongodb.Report, status string) error {
+	if err := h.strg.Report().ResolveReports(context.Background(), report.Link, status, c.Locals("admin").(string)); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/admin/reports")
+}
+
+func (h *handlerV1) HandleAdminDeleteBan(c *fiber.Ctx) error {
+	if err := h.strg.Ban().DeleteBan(context.Background(), c.Params("id")); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/admin/bans")
+}
+
+func (h *handlerV1) HandleAdminAddReserved(c *fiber.Ctx) error {
+	payload := struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	}{}
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	name := strings.ToLower(strings.TrimSpace(payload.Name))
+	if name == "" {
+		return c.Redirect(c.BaseURL() + "/admin/reserved")
+	}
+	reason := mongodb.ReservedReasonReserved
+	if payload.Reason == mongodb.ReservedReasonBlocked {
+		reason = mongodb.ReservedReasonBlocked
+	}
+
+	err := h.strg.Subdomain().AddReserved(context.Background(), &mongodb.Reserved{
+		Name:      name,
+		Reason:    reason,
+		CreatedBy: c.Locals("admin").(string),
+	})
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/admin/reserved")
+}
+
+func (h *handlerV1) HandleAdminDeleteReserved(c *fiber.Ctx) error {
+	if err := h.strg.Subdomain().DeleteReserved(context.Background(), c.Params("name")); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/admin/reserved")
+}
+
+// killTunnels stops active transfers matched by the filter, senders are told that the transfer was stopped
+func (h *handlerV1) killTunnels(match func(link string, val sshserver.Tunnel) bool) {
+	for link, val := range h.pipes.Snapshot() {
+		if !match(link, val) {
+			continue
+		}
+		// the transfer may have ended meanwhile, its channel is closed then already
+		if _, ok := h.pipes.Delete(link); ok {
+			close(val.KillChan)
+		}
+	}
+}
+
+// isSubdomainBanned hides pages and links of banned subdomains
+func (h *handlerV1) isSubdomainBanned(subdomain string) bool {
+	banned, err := h.strg.Ban().IsBanned(context.Background(), mongodb.BanSubdomain, subdomain)
+	if err != nil {
+		h.log.Error(err)
+	}
+
+	return banned
+}
@@ -2,16 +2,17 @@ package handlers
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/SaidovZohid/swiftsend.it/pkg/oidc"
 	"github.com/SaidovZohid/swiftsend.it/pkg/utils"
 	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
 	"github.com/gofiber/fiber/v2"
 	"github.com/mssola/useragent"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -20,64 +21,266 @@ const (
 	GithubProvider = "github"
 )
 
-// Github sign in or up redirect user to github
-func (h *handlerV1) HandleGithubAuth(c *fiber.Ctx) error {
-	// Create the dynamic redirect URL for login
-	redirectURL := fmt.Sprintf(
-		"https://github.com/login/oauth/authorize?client_id=%s&redirect_uri=%s",
-		h.cfg.Github.ClientID,
-		h.cfg.Github.RedirectURI,
-	)
+// HandleProviderLogin sends the user to sign in at the provider
+func (h *handlerV1) HandleProviderLogin(c *fiber.Ctx) error {
+	provider, ok := h.providers[c.Params("provider")]
+	if !ok {
+		return c.Redirect(c.BaseURL() + "/login")
+	}
 
-	return c.Redirect(redirectURL, 301)
+	return h.redirectToProvider(c, provider, "", "")
 }
 
-// Callback for github get code in query by github and authicated access token and get user data and  handle sign in or sign up
-func (h *handlerV1) HandleGithubAuthCallback(c *fiber.Ctx) error {
-	code := c.Query("code")
-	if code == "" {
-		return c.Redirect("/", 301)
+// redirectToProvider keeps state, nonce and PKCE verifier on the server. Signed state is also put in a cookie,
+// so the callback is finished only in the browser which started the login. Signed in users pass their id
+// and the action which is done on callback instead of signing in.
+func (h *handlerV1) redirectToProvider(c *fiber.Ctx, provider *oidc.Provider, userID, action string) error {
+	key := oidc.RandomString(32)
+	state := &oidc.State{
+		Provider:  provider.Name(),
+		Nonce:     oidc.RandomString(32),
+		Verifier:  oidc.RandomString(32),
+		CreatedAt: time.Now(),
+		UserID:    userID,
+		Action:    action,
 	}
-	githubAccessToken, err := h.getGithubAccessToken(code, h.cfg.Github.ClientID, h.cfg.Github.SecretKey)
-	if err != nil {
+	if err := h.loginStates.Save(context.Background(), key, state); err != nil {
+		h.log.Error(err)
 		return err
 	}
-	githubData, err := h.getGithubData(githubAccessToken)
+
+	signed := oidc.SignState(h.cfg.TokenSecretKey, key)
+	url, err := provider.AuthCodeURL(context.Background(), signed, state.Nonce, state.Verifier)
 	if err != nil {
+		h.log.Error(err)
 		return err
 	}
 
-	if githubData == nil {
-		return errors.New("github data is empty")
+	h.SetCookie(c, h.loginStateCookie(), signed, state.CreatedAt.Add(loginStateTTL))
+
+	return c.Redirect(url, fiber.StatusFound)
+}
+
+// HandleProviderCallback signs the user in or up after the provider sent the user back with the code
+func (h *handlerV1) HandleProviderCallback(c *fiber.Ctx) error {
+	provider, ok := h.providers[c.Params("provider")]
+	if !ok {
+		return c.Redirect(c.BaseURL() + "/login")
+	}
+
+	state, err := h.takeLoginState(c, provider.Name())
+	if err != nil {
+		return h.renderLogin(c, "Hmm 🤔 The login link has expired or was opened in another browser, please sign in again.")
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		// user denied access
+		return c.Redirect(c.BaseURL() + "/login")
+	}
+
+	claims, err := provider.Exchange(context.Background(), code, state.Verifier, state.Nonce)
+	if err == nil && claims.Subject == "" {
+		err = oidc.ErrInvalidIDToken
+	}
+	if err != nil {
+		h.log.Error(err)
+		return errors.New("failed to sign in with " + provider.DisplayName())
 	}
 
-	var id string
-	user, err := h.strg.User().FindUserByUsernameForLS(context.Background(), githubData.Username, GithubProvider)
-	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+	switch state.Action {
+	case loginActionLink:
+		return h.linkIdentity(c, provider, state.UserID, claims)
+	case loginActionDelete:
+		return h.deleteAccount(c, provider, state.UserID, claims)
+	}
+
+	user, err := h.findOrRegisterUser(context.Background(), provider.Name(), claims)
+	if errors.Is(err, errEmailTaken) {
+		return h.renderLogin(c, "An account with the email "+claims.Email+" already exists. Sign in the way you did before and link "+
+			provider.DisplayName()+" in settings.")
+	}
+	if err != nil {
 		h.log.Error(err)
 		return errors.New("something went unexpected")
 	}
+	id := user.Id.Hex()
+
+	if provider.Name() == GithubProvider && h.cfg.Github.SyncKeys {
+		h.syncGithubKeysOnLogin(id, claims.Subject)
+	}
+
+	return h.startSession(c, id, user.Username)
+}
+
+// actions of signed in users which are finished on callback
+const (
+	loginActionLink   = "link"
+	loginActionDelete = "delete"
+)
+
+func (h *handlerV1) loginStateCookie() string {
+	return h.cfg.AuthCookieName + "_state"
+}
+
+// takeLoginState checks signature of the state, compares it with the cookie of the browser and takes it from the store
+func (h *handlerV1) takeLoginState(c *fiber.Ctx, provider string) (*oidc.State, error) {
+	signed := c.Query("state")
+	cookie := c.Cookies(h.loginStateCookie())
+	h.SetCookie(c, h.loginStateCookie(), "", time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC))
+
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(signed)) != 1 {
+		return nil, oidc.ErrInvalidState
+	}
+	key, err := oidc.VerifyState(h.cfg.TokenSecretKey, signed)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := h.loginStates.Take(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	if state.Provider != provider {
+		return nil, oidc.ErrInvalidState
+	}
+
+	return state, nil
+}
+
+var errEmailTaken = errors.New("account with the email already exists")
+
+// findOrRegisterUser finds the user by the provider account. Accounts are never joined by username or email,
+// a user with the same verified email has to link the provider in settings.
+func (h *handlerV1) findOrRegisterUser(ctx context.Context, provider string, claims *oidc.Claims) (*mongodb.User, error) {
+	identity, err := h.strg.Identity().FindIdentity(ctx, provider, claims.Subject)
+	if err == nil {
+		if err := h.strg.Identity().TouchIdentity(ctx, identity.ID, claims.Username, claims.Email); err != nil {
+			h.log.Error(err)
+		}
+		return h.strg.User().FindUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	user, err := h.findLegacyUser(ctx, provider, claims)
+	if err != nil {
+		return nil, err
+	}
 	if user == nil {
-		userID, err := h.strg.User().RegisterUserFirst(context.Background(), &mongodb.User{
-			Username:               githubData.Username,
-			Fullname:               githubData.Name,
-			Email:                  githubData.Email,
-			CreatedAt:              time.Now().Format(time.RFC3339),
-			LogInAndSignUpProvider: GithubProvider,
-		})
+		if claims.Email != "" && claims.EmailVerified {
+			_, err := h.strg.User().FindUserByEmail(ctx, claims.Email)
+			if err == nil {
+				return nil, errEmailTaken
+			}
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, err
+			}
+		}
+
+		user, err = h.registerUser(ctx, provider, claims)
 		if err != nil {
-			return errors.New("failed to create new user")
+			return nil, err
 		}
-		a := userID.(primitive.ObjectID)
-		id = a.Hex()
-	} else {
-		id = user.Id.Hex()
 	}
 
+	err = h.strg.Identity().CreateIdentity(ctx, &mongodb.Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		UserID:   user.Id.Hex(),
+		Username: claims.Username,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// findLegacyUser finds accounts created before identities. They kept neither the subject nor the id of the user
+// at the provider, and usernames are given to someone else after a rename, so only the verified email of the
+// provider matches them. Other legacy users sign in and link the provider in settings. The account is taken over
+// only once, after that its identity is used.
+func (h *handlerV1) findLegacyUser(ctx context.Context, provider string, claims *oidc.Claims) (*mongodb.User, error) {
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, nil
+	}
+
+	user, err := h.strg.User().FindLegacyUserByEmail(ctx, claims.Email, provider)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := h.strg.Identity().GetIdentitiesByUserID(ctx, user.Id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			return nil, nil
+		}
+	}
+
+	return user, nil
+}
+
+func (h *handlerV1) registerUser(ctx context.Context, provider string, claims *oidc.Claims) (*mongodb.User, error) {
+	username, err := h.availableUsername(ctx, claims.Username, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &mongodb.User{
+		Username:               username,
+		Fullname:               claims.Name,
+		CreatedAt:              time.Now().Format(time.RFC3339),
+		LogInAndSignUpProvider: provider,
+	}
+	// unverified email could be someone else's, it is not kept
+	if claims.Email != "" && claims.EmailVerified {
+		user.Email = &claims.Email
+	}
+	if _, err := h.strg.User().RegisterUserFirst(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// availableUsername keeps usernames unique, alice signing up with google after alice of github becomes alice-google
+func (h *handlerV1) availableUsername(ctx context.Context, username, provider string) (string, error) {
+	for i := 0; i < 100; i++ {
+		name := username
+		switch {
+		case i == 1:
+			name = username + "-" + provider
+		case i > 1:
+			name = fmt.Sprintf("%v-%v-%v", username, provider, i)
+		}
+
+		_, err := h.strg.User().FindUserByUsername(ctx, name)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", errors.New("no free username for " + username)
+}
+
+// startSession creates session of the device and sets the cookie
+func (h *handlerV1) startSession(c *fiber.Ctx, id, username string) error {
 	token, _, err := utils.CreateToken(h.cfg, &utils.TokenParams{
 		UserID:   id,
 		Duration: time.Hour * 48,
-		Username: githubData.Username,
+		Username: username,
 	})
 	if err != nil {
 		return errors.New("failed to create jwt token, try again")
@@ -95,8 +298,8 @@ func (h *handlerV1) HandleGithubAuthCallback(c *fiber.Ctx) error {
 	// Get browser details
 	browserName, browserVersion := ua.Browser()
 
-	// Get client IP address using X-Forwarded-For header
-	ipAddress := c.Get("X-Forwarded-For")
+	// X-Forwarded-For is taken into account only when it comes from a trusted proxy
+	ipAddress := c.IP()
 	locationInfo, err := GetLocation(ipAddress, h.cfg)
 	if err != nil {
 		log.Println("Failed to get user info: ", err)
@@ -124,84 +327,6 @@ func (h *handlerV1) HandleGithubAuthCallback(c *fiber.Ctx) error {
 	return c.Redirect(c.BaseURL() + "/s/settings/account")
 }
 
-// Google sign in or up redirect user to google
-func (h *handlerV1) HandleGoogleAuth(c *fiber.Ctx) error {
-	url := h.cfg.Google.Conf.AuthCodeURL("randomstate")
-
-	return c.Redirect(url, 307)
-}
-
-// Callback for google get code in query by google and authicated access token and get user data and  handle sign in or sign up
-func (h *handlerV1) HandleGoogleCallback(c *fiber.Ctx) error {
-	if c.Query("state") != "randomstate" {
-		return errors.New("user denied sign in or up")
-	}
-
-	code := c.Query("code")
-
-	token, err := h.cfg.Google.Conf.Exchange(context.Background(), code)
-	if err != nil {
-		return err
-	}
-
-	data, err := h.getUserInfoFromGoogle(token.AccessToken)
-	if err != nil {
-		return err
-	}
-
-	var id string
-	user, err := h.strg.User().FindUserByEmail(context.Background(), data.Username)
-	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
-		h.log.Error(err)
-		return errors.New("something went unexpected")
-	}
-	if user == nil {
-		userID, err := h.strg.User().RegisterUserFirst(context.Background(), &mongodb.User{
-			Username:               data.Username,
-			Fullname:               data.Name,
-			Email:                  data.Email,
-			CreatedAt:              time.Now().Format(time.RFC3339),
-			LogInAndSignUpProvider: GoogleProvider,
-		})
-		if err != nil {
-			return errors.New("failed to create new user")
-		}
-		a := userID.(primitive.ObjectID)
-		id = a.Hex()
-	} else {
-		id = user.Id.Hex()
-	}
-
-	accessToken, _, err := utils.CreateToken(h.cfg, &utils.TokenParams{
-		UserID:   id,
-		Duration: time.Hour * 48,
-		Username: data.Username,
-	})
-	if err != nil {
-		return errors.New("failed to create jwt token, try again")
-	}
-
-	sessionID, err := h.strg.Session().CreateSession(context.Background(), &mongodb.Session{
-		AccessToken: accessToken,
-		UserID:      id,
-	})
-	if err != nil {
-		return errors.New("failed to create session, try again")
-	}
-
-	c.Cookie(&fiber.Cookie{
-		Name:     h.cfg.AuthCookieName,
-		Value:    sessionID,
-		Path:     "/",
-		Expires:  time.Now().Add(time.Hour * 48),
-		HTTPOnly: true,
-		Secure:   true,
-		SameSite: fiber.CookieSameSiteLaxMode,
-	})
-
-	return c.Redirect(c.BaseURL() + "/s/settings/account")
-}
-
 // Render sign up page
 func (h *handlerV1) HandleSignUpPage(c *fiber.Ctx) error {
 	_, id := h.getAuth(c)
@@ -209,7 +334,8 @@ func (h *handlerV1) HandleSignUpPage(c *fiber.Ctx) error {
 		return c.Redirect(c.BaseURL())
 	}
 	return c.Render("signup/index", fiber.Map{
-		"links": UserNotVerifiedHeader,
+		"links":     UserNotVerifiedHeader,
+		"providers": h.loginProviders,
 	})
 }
 
@@ -220,8 +346,14 @@ func (h *handlerV1) HandleLoginPage(c *fiber.Ctx) error {
 		return c.Redirect(c.BaseURL() + "/s/settings/account")
 	}
 
+	return h.renderLogin(c, "")
+}
+
+func (h *handlerV1) renderLogin(c *fiber.Ctx, errMsg string) error {
 	return c.Render("login/index", fiber.Map{
-		"links": UserNotVerifiedHeader,
+		"links":     UserNotVerifiedHeader,
+		"providers": h.loginProviders,
+		"error":     errMsg,
 	})
 }
 
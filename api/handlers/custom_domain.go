@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/domains"
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (h *handlerV1) HandleSettingGetDomain(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Render("settings/domain", h.domainPageData(user, ""))
+}
+
+func (h *handlerV1) HandleSettingPostDomain(c *fiber.Ctx) error {
+	payload := struct {
+		Domain string `json:"domain"`
+	}{}
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	data, _ := h.getAuth(c)
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	if user.Subdomain == nil {
+		return c.Render("settings/domain", h.domainPageData(user, "Claim your verified subdomain first, custom domains are only for verified users! 🌟"))
+	}
+
+	domain, err := domains.Normalize(payload.Domain)
+	if err != nil {
+		return c.Render("settings/domain", h.domainPageData(user, "Apologies for the inconvenience! 😊 The domain you entered is invalid!"))
+	}
+
+	if domain == h.links.Host() || strings.HasSuffix(domain, "."+h.links.Host()) {
+		return c.Render("settings/domain", h.domainPageData(user, "Oops! 😊 Subdomains of JTF can not be used as a custom domain."))
+	}
+
+	owner, err := h.strg.User().FindUserByCustomDomain(context.Background(), domain)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		h.log.Error(err)
+		return err
+	}
+	if owner != nil && owner.Id != user.Id {
+		return c.Render("settings/domain", h.domainPageData(user, "Oops! 😊 The domain you entered is already in use!"))
+	}
+
+	token, err := domains.NewToken()
+	if err != nil {
+		return err
+	}
+
+	err = h.strg.User().SetCustomDomain(context.Background(), data.UserID, &mongodb.CustomDomain{
+		Domain:    domain,
+		Token:     token,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/s/settings/domain")
+}
+
+func (h *handlerV1) HandleSettingVerifyDomain(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	if user.CustomDomain == nil || user.CustomDomain.Verified {
+		return c.Redirect(c.BaseURL() + "/s/settings/domain")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	method, err := h.verifier.Verify(ctx, user.CustomDomain.Domain, user.CustomDomain.Token)
+	if err != nil {
+		return c.Render("settings/domain", h.domainPageData(user, "We could not find the verification token yet 🔍 DNS changes can take a while, please try again in a few minutes."))
+	}
+
+	// somebody else could verify the same domain in the meantime
+	owner, err := h.strg.User().FindUserByCustomDomain(context.Background(), user.CustomDomain.Domain)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		h.log.Error(err)
+		return err
+	}
+	if owner != nil && owner.Id != user.Id {
+		return c.Render("settings/domain", h.domainPageData(user, "Oops! 😊 The domain you entered is already in use!"))
+	}
+
+	err = h.strg.User().VerifyCustomDomain(context.Background(), data.UserID, method, time.Now().Format(time.RFC3339))
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	h.customDomains.forget(user.CustomDomain.Domain)
+
+	return c.Redirect(c.BaseURL() + "/s/settings/domain")
+}
+
+func (h *handlerV1) HandleSettingDeleteDomain(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	if err := h.strg.User().DeleteCustomDomain(context.Background(), data.UserID); err != nil {
+		h.log.Error(err)
+		return err
+	}
+	if user.CustomDomain != nil {
+		h.customDomains.forget(user.CustomDomain.Domain)
+	}
+
+	return c.Redirect(c.BaseURL() + "/s/settings/domain")
+}
+
+func (h *handlerV1) domainPageData(user *mongodb.User, errMsg string) fiber.Map {
+	data := fiber.Map{
+		"username":   user.Username,
+		"links":      UserVerifiedHeader,
+		"verified":   user.Subdomain != nil,
+		"well_known": domains.WellKnownPath,
+	}
+	if errMsg != "" {
+		data["error"] = errMsg
+	}
+	if user.CustomDomain != nil {
+		data["domain"] = user.CustomDomain
+		data["record"] = domains.RecordPrefix + user.CustomDomain.Domain
+		data["domain_link"] = h.links.Domain(user.CustomDomain.Domain)
+	}
+
+	return data
+}
+
+const (
+	// customDomainTTL is how long the host router remembers a custom domain, found or not
+	customDomainTTL = time.Minute
+	// hosts come from the Host header of any request, so the cache is bounded
+	customDomainCacheSize = 10000
+)
+
+type customDomainRoute struct {
+	subdomain string // empty when the host is not a verified custom domain
+	expiresAt time.Time
+}
+
+// customDomainCache keeps subdomains of custom domains, the host router does not query the database on every request
+type customDomainCache struct {
+	mu     sync.Mutex
+	routes map[string]customDomainRoute
+}
+
+func newCustomDomainCache() *customDomainCache {
+	return &customDomainCache{routes: make(map[string]customDomainRoute)}
+}
+
+// get returns the subdomain of the host, false when the host was not looked up recently
+func (c *customDomainCache) get(host string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	route, ok := c.routes[host]
+	if !ok || now.After(route.expiresAt) {
+		return "", false
+	}
+
+	return route.subdomain, true
+}
+
+func (c *customDomainCache) set(host, subdomain string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.routes) >= customDomainCacheSize {
+		for h, route := range c.routes {
+			if now.After(route.expiresAt) {
+				delete(c.routes, h)
+			}
+		}
+		// full of fresh entries, they are all looked up again
+		if len(c.routes) >= customDomainCacheSize {
+			c.routes = make(map[string]customDomainRoute)
+		}
+	}
+	c.routes[host] = customDomainRoute{subdomain: subdomain, expiresAt: now.Add(customDomainTTL)}
+}
+
+// forget drops the host after its owner changed it, so the change is seen right away on this instance
+func (c *customDomainCache) forget(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.routes, host)
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/oidc"
+	"github.com/SaidovZohid/swiftsend.it/sshserver"
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// sessions are exported without access tokens
+type exportSession struct {
+	ID        string `json:"id"`
+	IpAddress string `json:"ip_address"`
+	Device    string `json:"device"`
+	Timezone  string `json:"timezone"`
+	LastLogin string `json:"last_login"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (h *handlerV1) HandleSettingGetDanger(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+
+	return h.renderDanger(c, data.UserID, data.Username, "")
+}
+
+func (h *handlerV1) renderDanger(c *fiber.Ctx, userID, username, errMsg string) error {
+	identities, err := h.strg.Identity().GetIdentitiesByUserID(context.Background(), userID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	// deletion is confirmed by signing in again with one of linked providers,
+	// accounts older than identities can use any of them
+	providers := make([]loginProvider, 0, len(h.loginProviders))
+	for _, p := range h.loginProviders {
+		linked := len(identities) == 0
+		for _, identity := range identities {
+			linked = linked || identity.Provider == p.Name
+		}
+		if linked {
+			providers = append(providers, p)
+		}
+	}
+
+	return c.Render("settings/danger", fiber.Map{
+		"username":  username,
+		"providers": providers,
+		"error":     errMsg,
+		"links":     UserVerifiedHeader,
+	})
+}
+
+// HandleSettingExport sends everything kept about the user as a zip of json files
+func (h *handlerV1) HandleSettingExport(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+	ctx := context.Background()
+
+	user, err := h.strg.User().FindUserByID(ctx, data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	identities, err := h.strg.Identity().GetIdentitiesByUserID(ctx, data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	sessions, err := h.strg.Session().GetSessionsByUserID(ctx, data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	transfers, err := h.strg.Transfer().GetTransfersByUserID(ctx, data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	exported := make([]exportSession, 0, len(sessions))
+	for _, s := range sessions {
+		exported = append(exported, exportSession{
+			ID:        s.SessionID.Hex(),
+			IpAddress: s.IpAddress,
+			Device:    s.Device,
+			Timezone:  s.Timezone,
+			LastLogin: s.LastLogin,
+			CreatedAt: s.CreatedAt,
+		})
+	}
+	keys := user.Keys
+	user.Keys = nil
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"profile.json", user},
+		{"keys.json", keys},
+		{"sign_in_methods.json", identities},
+		{"sessions.json", exported},
+		{"transfers.json", transfers},
+	}
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.v); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	h.audit(c, mongodb.AuditDataExported, data.UserID, data.Username, "")
+
+	c.Set("Content-Type", "application/zip")
+	c.Set("Content-Disposition", `attachment; filename="jtf-export-`+data.UserID+`.zip"`)
+	return c.Send(buf.Bytes())
+}
+
+// HandleSettingDeleteAccount checks the typed username and sends the user to sign in again, the account is deleted on callback
+func (h *handlerV1) HandleSettingDeleteAccount(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+	payload := struct {
+		Confirm string `json:"confirm"`
+	}{}
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(payload.Confirm) != data.Username {
+		return h.renderDanger(c, data.UserID, data.Username, "Hmm 🤔 Type your username to confirm!")
+	}
+	provider, ok := h.providers[c.Params("provider")]
+	if !ok {
+		return c.Redirect(c.BaseURL() + "/s/settings/danger")
+	}
+
+	return h.redirectToProvider(c, provider, data.UserID, loginActionDelete)
+}
+
+// deleteAccount deletes the account once the user signed in again with an account linked to it
+func (h *handlerV1) deleteAccount(c *fiber.Ctx, provider *oidc.Provider, userID string, claims *oidc.Claims) error {
+	data, _ := h.getAuth(c)
+	if data == nil || data.UserID != userID {
+		return c.Redirect(c.BaseURL() + "/login")
+	}
+	ctx := context.Background()
+
+	ownerID := ""
+	identity, err := h.strg.Identity().FindIdentity(ctx, provider.Name(), claims.Subject)
+	switch {
+	case err == nil:
+		ownerID = identity.UserID
+	case errors.Is(err, mongo.ErrNoDocuments):
+		legacy, err := h.findLegacyUser(ctx, provider.Name(), claims)
+		if err != nil {
+			h.log.Error(err)
+			return err
+		}
+		if legacy != nil {
+			ownerID = legacy.Id.Hex()
+		}
+	default:
+		h.log.Error(err)
+		return err
+	}
+	if ownerID != userID {
+		return h.renderDanger(c, data.UserID, data.Username, "Oops! 😕 You signed in with "+provider.DisplayName()+" account which is not linked to this account.")
+	}
+
+	user, err := h.strg.User().FindUserByID(ctx, userID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	if err := h.removeAccount(ctx, user); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	h.audit(c, mongodb.AuditAccountDeleted, userID, user.Username, "confirmed with "+provider.Name())
+
+	h.SetCookie(c, h.cfg.AuthCookieName, "", time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC))
+	return c.Redirect(c.BaseURL() + "/")
+}
+
+// removeAccount stops files being sent with the account and deletes everything kept about the user,
+// the subdomain and subdomains held after rename become free right away
+func (h *handlerV1) removeAccount(ctx context.Context, user *mongodb.User) error {
+	userID := user.Id.Hex()
+
+	fingerprints := make(map[string]bool, len(user.Keys))
+	for _, key := range user.Keys {
+		fingerprints[key.SSHHash] = true
+	}
+	h.killTunnels(func(_ string, val sshserver.Tunnel) bool {
+		if val.User == nil {
+			return false
+		}
+		return fingerprints[val.User.Fingerprint] || (user.Subdomain != nil && val.User.Subdomain == *user.Subdomain)
+	})
+
+	if err := h.strg.Session().DeleteSessionsByUserID(ctx, userID, ""); err != nil {
+		return err
+	}
+	if err := h.strg.Identity().DeleteIdentitiesByUserID(ctx, userID); err != nil {
+		return err
+	}
+	if err := h.strg.Transfer().DeleteTransfersByUserID(ctx, userID); err != nil {
+		return err
+	}
+	if err := h.strg.Subdomain().DeleteReleasedByUserID(ctx, userID); err != nil {
+		return err
+	}
+
+	return h.strg.User().DeleteUser(ctx, userID)
+}
+
+// audit records the action of the signed in user, failing to record it does not fail the action
+func (h *handlerV1) audit(c *fiber.Ctx, action, userID, username, details string) {
+	err := h.strg.Audit().AddEvent(context.Background(), &mongodb.AuditEvent{
+		Action:    action,
+		UserID:    userID,
+		Username:  username,
+		Actor:     username,
+		IPAddress: c.IP(),
+		Details:   details,
+	})
+	if err != nil {
+		h.log.Error(err)
+	}
+}
@@ -4,7 +4,7 @@ import "github.com/gofiber/fiber/v2"
 
 func (h *handlerV1) HandleDeleteSentFile(c *fiber.Ctx) error {
 	link := c.Params("link")
-	val, ok := h.pipes[link]
+	val, ok := h.pipes.Delete(link)
 	if !ok {
 		// log.Println("Something here")
 		return c.Render("errors/404", fiber.Map{
@@ -14,8 +14,6 @@ func (h *handlerV1) HandleDeleteSentFile(c *fiber.Ctx) error {
 		})
 	}
 
-	// Delete the tunnel from the map and send to done channel
-	delete(h.pipes, link)
 	// close the listening channel
 	close(val.DeleteChan)
 
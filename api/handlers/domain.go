@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 
+	"github.com/SaidovZohid/swiftsend.it/pkg/links"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -18,13 +19,16 @@ func (h *handlerV1) HandleGetSubdomainInfo(c *fiber.Ctx) error {
 		})
 	}
 
-	if subdomain == "unknown" || subdomain == "direct" {
+	if subdomain == links.Unknown || subdomain == links.Direct || subdomain == links.Delete {
 		return c.Redirect(h.cfg.BaseURL, 301)
 	}
 
 	info, err := h.strg.User().FindUserBySubdomain(context.Background(), subdomain)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
+			if ok, err := h.redirectRenamed(c, subdomain, ""); ok || err != nil {
+				return err
+			}
 			return c.Render("errors/404", fiber.Map{
 				"what": "Subdomain",
 				"link": h.cfg.BaseURL,
@@ -33,6 +37,13 @@ func (h *handlerV1) HandleGetSubdomainInfo(c *fiber.Ctx) error {
 		}
 		return err
 	}
+	if h.isSubdomainBanned(subdomain) {
+		return c.Render("errors/404", fiber.Map{
+			"what": "Subdomain",
+			"link": h.cfg.BaseURL,
+			"text": "The subdomain you are looking for is not available. 🚫",
+		})
+	}
 	ln := len(info.Keys)
 
 	data, _ := h.getAuth(c)
@@ -52,7 +63,7 @@ func (h *handlerV1) HandleGetSubdomainInfo(c *fiber.Ctx) error {
 		return c.Render("subdomain/index", fiber.Map{
 			"ln":        ln,
 			"owns":      *user.Subdomain == subdomain,
-			"link":      subdomain + "." + h.cfg.BaseURL[8:],
+			"link":      h.links.SubdomainHost(subdomain),
 			"keys":      info.Keys,
 			"link_site": h.cfg.BaseURL,
 			"username":  user.Username,
@@ -64,7 +75,7 @@ func (h *handlerV1) HandleGetSubdomainInfo(c *fiber.Ctx) error {
 	return c.Render("subdomain/index", fiber.Map{
 		"ln":        ln,
 		"owns":      false,
-		"link":      subdomain + "." + h.cfg.BaseURL[8:],
+		"link":      h.links.SubdomainHost(subdomain),
 		"keys":      info.Keys,
 		"link_site": h.cfg.BaseURL,
 		"links":     UserNotVerifiedHeader,
@@ -4,11 +4,20 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
 	"time"
 
+	"github.com/SaidovZohid/swiftsend.it/pkg/compress"
+	"github.com/SaidovZohid/swiftsend.it/pkg/links"
+	"github.com/SaidovZohid/swiftsend.it/sshserver"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -24,7 +33,7 @@ func (h *handlerV1) HandleDownloadPaage(c *fiber.Ctx) error {
 		})
 	}
 
-	if subdomain != "unknown" {
+	if subdomain != links.Unknown {
 		user, err := h.strg.User().FindUserBySubdomain(context.Background(), subdomain)
 		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
 			return c.Render("errors/404", fiber.Map{
@@ -33,7 +42,10 @@ func (h *handlerV1) HandleDownloadPaage(c *fiber.Ctx) error {
 				"text": "The subdomain you are looking for doesn't exist. But don't worry, you can create it and make it your own by clicking the button below! 🚀✨",
 			})
 		}
-		if user == nil {
+		if user == nil || h.isSubdomainBanned(subdomain) {
+			if ok, err := h.redirectRenamed(c, subdomain, link); ok || err != nil {
+				return err
+			}
 			return c.Render("errors/404", fiber.Map{
 				"what": "Subdomain and Link",
 				"link": h.cfg.BaseURL,
@@ -42,7 +54,7 @@ func (h *handlerV1) HandleDownloadPaage(c *fiber.Ctx) error {
 		}
 	}
 
-	val, ok := h.pipes[link]
+	val, ok := h.pipes.Get(link)
 	if !ok {
 		// log.Println("Something here")
 		return c.Render("errors/404", fiber.Map{
@@ -69,8 +81,17 @@ func (h *handlerV1) HandleDownloadPaage(c *fiber.Ctx) error {
 		linkTime = fmt.Sprintf("%v minutes %v seconds ago", minutes, seconds)
 	}
 
+	// Remaining time of the link, the page keeps counting it down from the events stream
+	expiresIn := int64(time.Until(val.ExpiresAt).Seconds())
+	if expiresIn < 0 {
+		expiresIn = 0
+	}
+	expireTime := fmt.Sprintf("in %v seconds", expiresIn%60)
+	if expiresIn >= 60 {
+		expireTime = fmt.Sprintf("in %v minutes %v seconds", expiresIn/60, expiresIn%60)
+	}
+
 	name := "Unknown person"
-	expireTime := "in 15 minutes"
 	var msg *string
 	if val.User.Options != nil {
 		if val.User.Subdomain != "" && val.User.Options.From != nil {
@@ -81,27 +102,39 @@ func (h *handlerV1) HandleDownloadPaage(c *fiber.Ctx) error {
 			name = *val.User.Options.From
 		}
 
-		if val.User.Options.Save != nil {
-			if *val.User.Options.Save == 1 {
-				expireTime = fmt.Sprintf("in %v minute", *val.User.Options.Save)
-			} else {
-				expireTime = fmt.Sprintf("in %v minutes", *val.User.Options.Save)
-			}
-		}
-
 		if val.User.Options.Message != nil {
 			msg = val.User.Options.Message
 		}
 	}
 
+	// content of password protected files is not shown before the password is entered
+	protected := val.User.Options.PasswordProtected()
+	var preview *filePreview
+	var sha256Hex, blake2bHex string
+	if data, ok := previewData(val); ok && !protected {
+		preview = buildPreview(previewFilename(link, val), data)
+	}
+	if !protected {
+		sha256Hex, blake2bHex = val.File.Checksum.SHA256Hex(), val.File.Checksum.BLAKE2bHex()
+	}
+
 	if val.User.Subdomain != "" {
 		// verified user
 		return c.Render("download/index", fiber.Map{
 			"is_verified": true,
 			"name":        name,
-			"link":        h.cfg.BaseURL + "/direct/" + link,
+			"link":        h.links.Direct(link),
 			"link_time":   linkTime,
 			"expire_time": expireTime,
+			"expires_in":  expiresIn,
+			"code":        link,
+			"preview":     preview,
+			"protected":   protected,
+			"sha256":      sha256Hex,
+			"blake2b":     blake2bHex,
+			"scanning":    !val.Frozen && !val.Downloadable(),
+			"frozen":      val.Frozen,
+			"reasons":     reportReasons,
 			"msg":         msg,
 			"base_url":    h.cfg.BaseURL,
 		})
@@ -111,22 +144,26 @@ func (h *handlerV1) HandleDownloadPaage(c *fiber.Ctx) error {
 	return c.Render("download/index", fiber.Map{
 		"is_verified": false,
 		"name":        name,
-		"link":        h.cfg.BaseURL + "/direct/" + link,
+		"link":        h.links.Direct(link),
 		"link_time":   linkTime,
 		"expire_time": expireTime,
+		"expires_in":  expiresIn,
+		"code":        link,
+		"preview":     preview,
+		"protected":   protected,
+		"sha256":      sha256Hex,
+		"blake2b":     blake2bHex,
+		"scanning":    !val.Frozen && !val.Downloadable(),
+		"frozen":      val.Frozen,
+		"reasons":     reportReasons,
 		"msg":         msg,
 		"base_url":    h.cfg.BaseURL,
 	})
 }
 
-type ioWriterReader struct {
-	io.Writer
-	io.Reader
-}
-
 func (h *handlerV1) HandleDirectDownload(c *fiber.Ctx) error {
 	link := c.Params("link")
-	val, ok := h.pipes[link]
+	val, ok := h.pipes.Get(link)
 	if !ok {
 		return c.Render("errors/404", fiber.Map{
 			"what": "File",
@@ -135,56 +172,194 @@ func (h *handlerV1) HandleDirectDownload(c *fiber.Ctx) error {
 		})
 	}
 
-	// Create a buffer to hold the zip file contents
-	buf := new(bytes.Buffer)
-
-	// Create a zip writer
-	zipWriter := zip.NewWriter(buf)
+	if val.Frozen {
+		return c.Status(fiber.StatusLocked).Render("download/frozen", fiber.Map{
+			"title": "Under review",
+			"text":  "The link was reported and is frozen until our moderators review it. 🚩",
+			"link":  h.cfg.BaseURL,
+		})
+	}
+	if !val.Downloadable() {
+		c.Set(fiber.HeaderRetryAfter, "5")
+		return c.Status(fiber.StatusConflict).Render("errors/409", fiber.Map{
+			"link": h.cfg.BaseURL,
+			"text": "The file is being checked for malware, try again in a few seconds. 🔎",
+		})
+	}
 
-	// Create a new file in the zip archive
-	filename := link
-	if val.User.Options != nil {
-		if val.User.Options.Filename != nil {
-			filename = *val.User.Options.Filename
+	if val.User.Options.PasswordProtected() {
+		// form of the download page posts it, curl users can pass it in the query
+		pw := c.FormValue("pw")
+		if !passwordMatches(val, pw) {
+			wrong := ""
+			if pw != "" {
+				wrong = "Wrong password, try again 🔒"
+			}
+			return c.Status(fiber.StatusUnauthorized).Render("download/password", fiber.Map{
+				"link":  h.links.Direct(link),
+				"error": wrong,
+			})
 		}
 	}
-	file, err := zipWriter.Create(filename)
-	if err != nil {
-		return err
+
+	data, ok := peekFile(val)
+	if !ok {
+		return c.SendStatus(fiber.StatusNotFound)
 	}
 
-	// Create a custom io.ReadWriter that reads from and writes to the io.Writer
-	ioReadWriter := &ioWriterReader{
-		Writer: file,
-		Reader: val.File.W.(io.Reader),
+	// text is compressed on the fly for clients which accept it, the zip keeps it stored then so it is not
+	// compressed twice. Pre-compressed uploads go into the zip as they are.
+	filename := previewFilename(link, val)
+	coding := ""
+	method := zip.Deflate
+	if val.File.Encoding == "" && compress.Compressible(fileContentType(filename, data)) {
+		c.Vary(fiber.HeaderAcceptEncoding)
+		if coding = compress.Negotiate(c.Get(fiber.HeaderAcceptEncoding)); coding != "" {
+			method = zip.Store
+		}
 	}
 
-	// Copy the content from the io.Writer to the zip file, and notify progress
-	_, err = io.Copy(ioReadWriter, ioReadWriter)
+	body, err := zipFile(filename, data, val.File, method)
 	if err != nil {
+		h.log.Error(err)
 		return err
 	}
+	if coding != "" {
+		if body, err = compress.Encode(body, coding); err != nil {
+			h.log.Error(err)
+			return err
+		}
+	}
 
-	// Close the zip writer
-	err = zipWriter.Close()
-	if err != nil {
-		return err
+	// the download is counted under the lock before the file is sent, so parallel downloads do not go over the
+	// limit and a link frozen meanwhile is not handed out
+	claimed := false
+	val, ok = h.pipes.Update(link, func(t *sshserver.Tunnel) {
+		if t.Downloadable() && t.Downloads < t.User.Options.MaxDownloads() {
+			t.Downloads++
+			claimed = true
+		}
+	})
+	if !ok || !claimed {
+		return c.Status(fiber.StatusNotFound).Render("errors/404", fiber.Map{
+			"what": "File",
+			"link": h.cfg.BaseURL,
+			"text": "The provided link is either invalid or has already expired. 🚫🔗 Please ensure you have a valid and up-to-date link. ⏳",
+		})
 	}
 
 	// Set the appropriate headers
+	if coding != "" {
+		c.Set(fiber.HeaderContentEncoding, coding)
+	}
 	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, "jtf.zip"))
 	c.Set("Content-Type", "application/zip")
-	c.Set("Content-Length", fmt.Sprintf("%d", val.File.FileSize))
+	c.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	setChecksumHeaders(c, val, body)
 
 	// Send the zip file as the response
-	err = c.Send(buf.Bytes())
+	err = c.Send(body)
 	if err != nil {
+		h.pipes.Update(link, func(t *sshserver.Tunnel) { t.Downloads-- })
 		return err
 	}
+	if val.Downloads < val.User.Options.MaxDownloads() {
+		return nil
+	}
+
 	// Delete the tunnel from the map and send to done channel
-	delete(h.pipes, link)
-	// close the listening channel
-	close(val.DoneChan)
+	if _, ok := h.pipes.Delete(link); ok {
+		// close the listening channel
+		close(val.DoneChan)
+	}
 
 	return nil
 }
+
+// zipFile puts the file into zip, pre-compressed uploads are copied into it without compressing them again
+func zipFile(filename string, data []byte, file sshserver.File, method uint16) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(buf)
+
+	var (
+		w   io.Writer
+		err error
+	)
+	if file.Encoding == compress.Gzip {
+		var crc uint32
+		if data, crc, err = compress.GzipMember(data); err != nil {
+			return nil, err
+		}
+		w, err = zipWriter.CreateRaw(&zip.FileHeader{
+			Name:               filename,
+			Method:             zip.Deflate,
+			CRC32:              crc,
+			CompressedSize64:   uint64(len(data)),
+			UncompressedSize64: uint64(file.ContentSize),
+		})
+	} else {
+		w, err = zipWriter.CreateHeader(&zip.FileHeader{Name: filename, Method: method})
+	}
+	if err != nil {
+		return nil, err
+	}
+	// uploaded bytes are only read, so the file can be downloaded again
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zipWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// fileContentType guesses type of the file by its extension or by its content when the extension says nothing
+func fileContentType(filename string, data []byte) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(filename)); contentType != "" {
+		return contentType
+	}
+
+	return http.DetectContentType(data)
+}
+
+// HandleDirectChecksum serves the checksum of the file in sha256sum format, so the file extracted from the zip can
+// be checked with "sha256sum -c"
+func (h *handlerV1) HandleDirectChecksum(c *fiber.Ctx) error {
+	link := c.Params("link")
+	val, ok := h.pipes.Get(link)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).SendString("link is either invalid or has already expired\n")
+	}
+	if val.Frozen {
+		return c.Status(fiber.StatusLocked).SendString("link was reported and is under review\n")
+	}
+	if !val.Downloadable() {
+		c.Set(fiber.HeaderRetryAfter, "5")
+		return c.Status(fiber.StatusConflict).SendString("file is being checked for malware, try again in a few seconds\n")
+	}
+	if val.User.Options.PasswordProtected() && !passwordMatches(val, c.FormValue("pw")) {
+		return c.Status(fiber.StatusUnauthorized).SendString("file is password protected, pass it with ?pw=\n")
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+	return c.SendString(val.File.Checksum.SHA256Hex() + "  " + previewFilename(link, val) + "\n")
+}
+
+func passwordMatches(val sshserver.Tunnel, pw string) bool {
+	return subtle.ConstantTimeCompare([]byte(pw), []byte(*val.User.Options.Password)) == 1
+}
+
+// setChecksumHeaders tells checksums of the download. Digest and Repr-Digest are of the zip which is sent, so
+// download tools can check the response, X-Checksum-* are of the file inside it.
+func setChecksumHeaders(c *fiber.Ctx, val sshserver.Tunnel, body []byte) {
+	sum := sha256.Sum256(body)
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+	c.Set("Digest", "sha-256="+digest)
+	c.Set("Repr-Digest", "sha-256=:"+digest+":")
+
+	c.Set("X-Checksum-Sha256", val.File.Checksum.SHA256Hex())
+	if b2 := val.File.Checksum.BLAKE2bHex(); b2 != "" {
+		c.Set("X-Checksum-Blake2b", b2)
+	}
+}
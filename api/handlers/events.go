@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/sshserver"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	linkStateActive     = "active"
+	linkStateDownloaded = "downloaded"
+	linkStateDeleted    = "deleted"
+	linkStateExpired    = "expired"
+	linkStateGone       = "gone"
+	// the scanner did not find the file clean yet, or took the link down
+	linkStateScanning    = "scanning"
+	linkStateQuarantined = "quarantined"
+	// the link was reported and waits for review
+	linkStateFrozen = "frozen"
+)
+
+type linkStatus struct {
+	State     string `json:"state"`
+	Remaining int64  `json:"remaining"` // seconds left before the link expires
+	Size      int64  `json:"size"`      // uploaded bytes
+}
+
+// HandleLinkEvents streams the status of the link to the download page as server-sent events
+func (h *handlerV1) HandleLinkEvents(c *fiber.Ctx) error {
+	link := c.Params("link")
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	val, ok := h.pipes.Get(link)
+	if !ok {
+		return c.SendString(formatEvent(linkStatus{State: linkStateGone}))
+	}
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		status := linkStatus{State: linkStateActive}
+		for {
+			if isLive(status.State) {
+				status.State = h.liveState(link, val)
+			}
+			status.Size = val.File.FileSize
+			status.Remaining = int64(time.Until(val.ExpiresAt).Seconds())
+			if status.Remaining < 0 {
+				status.Remaining = 0
+			}
+
+			if _, err := w.WriteString(formatEvent(status)); err != nil {
+				return
+			}
+			// client closed the page
+			if err := w.Flush(); err != nil {
+				return
+			}
+			if !isLive(status.State) {
+				return
+			}
+
+			select {
+			case <-ticker.C:
+			case <-val.DoneChan:
+				status.State = linkStateDownloaded
+			case <-val.DeleteChan:
+				status.State = linkStateDeleted
+			case <-val.KillChan:
+				status.State = linkStateDeleted
+			case <-val.ExpireChan:
+				status.State = linkStateExpired
+			}
+		}
+	})
+
+	return nil
+}
+
+// liveState tells the state of the link which is not finished yet, it can be reported or scanned
+func (h *handlerV1) liveState(link string, val sshserver.Tunnel) string {
+	if cur, ok := h.pipes.Get(link); ok && cur.Frozen {
+		return linkStateFrozen
+	}
+
+	switch val.Scan.Status() {
+	case sshserver.ScanPending:
+		return linkStateScanning
+	case sshserver.ScanInfected, sshserver.ScanFailed:
+		return linkStateQuarantined
+	}
+
+	return linkStateActive
+}
+
+// isLive tells if the link can still be downloaded, now or after the scan or the review
+func isLive(state string) bool {
+	return state == linkStateActive || state == linkStateScanning || state == linkStateFrozen
+}
+
+func formatEvent(status linkStatus) string {
+	data, _ := json.Marshal(status)
+	return fmt.Sprintf("event: status\ndata: %s\n\n", data)
+}
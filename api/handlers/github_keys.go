@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/sshkeys"
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// syncGithubKeys makes GitHub-managed keys of the user equal to public keys of the GitHub account with the id.
+// Keys added by hand are never touched and keys linked to other accounts are skipped.
+func (h *handlerV1) syncGithubKeys(ctx context.Context, user *mongodb.User, githubID string) error {
+	lines, err := h.keyFetcher.Fetch(ctx, githubID)
+	if err != nil {
+		return err
+	}
+
+	var synced []string
+	manual := make(map[string]bool)
+	for _, key := range user.Keys {
+		if key.Source == mongodb.KeySourceGithub {
+			synced = append(synced, key.SSHHash)
+		} else {
+			manual[key.SSHHash] = true
+		}
+	}
+	added, removed := sshkeys.Reconcile(sshkeys.Parse(lines), synced)
+
+	// kept keys keep name, defaults and last use
+	gone := make(map[string]bool, len(removed))
+	for _, fingerprint := range removed {
+		gone[fingerprint] = true
+	}
+	keys := make([]mongodb.Keys, 0, len(synced)+len(added))
+	for _, key := range user.Keys {
+		if key.Source == mongodb.KeySourceGithub && !gone[key.SSHHash] {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range added {
+		if manual[key.Fingerprint] {
+			continue
+		}
+		owner, err := h.strg.User().GetUserInfoByHashSSH(ctx, key.Fingerprint)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		if owner != nil && owner.Id != user.Id {
+			continue
+		}
+
+		keys = append(keys, mongodb.Keys{
+			ID:        primitive.NewObjectID(),
+			Name:      "GitHub " + key.Type,
+			SSHHash:   key.Fingerprint,
+			CreatedAt: time.Now().Format(time.RFC3339),
+			Source:    mongodb.KeySourceGithub,
+		})
+	}
+
+	return h.strg.User().ReplaceSourceKeys(ctx, user.Id.Hex(), mongodb.KeySourceGithub, keys)
+}
+
+// syncGithubKeysOnLogin does not fail the login, GitHub being down only leaves old keys
+func (h *handlerV1) syncGithubKeysOnLogin(userID, githubID string) {
+	user, err := h.strg.User().FindUserByID(context.Background(), userID)
+	if err == nil {
+		err = h.syncGithubKeys(context.Background(), user, githubID)
+	}
+	if err != nil {
+		h.log.Error(err)
+	}
+}
+
+func (h *handlerV1) HandleSettingSyncGithubKeys(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	identity, err := h.githubIdentity(context.Background(), user.Id.Hex())
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	if identity == nil {
+		return c.Redirect(c.BaseURL() + "/s/settings/keys")
+	}
+
+	if err := h.syncGithubKeys(context.Background(), user, identity.Subject); err != nil {
+		h.log.Error(err)
+		return h.renderKeys(c, user, "Oops! 😕 We could not get your keys from GitHub, try again later.")
+	}
+
+	return c.Redirect(c.BaseURL() + "/s/settings/keys")
+}
+
+// SyncGithubKeysEvery resyncs keys of all GitHub users with the interval, it blocks so run it in a goroutine
+func (h *handlerV1) SyncGithubKeysEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		identities, err := h.strg.Identity().FindIdentitiesByProvider(context.Background(), GithubProvider)
+		if err != nil {
+			h.log.Error(err)
+			continue
+		}
+
+		for _, identity := range identities {
+			user, err := h.strg.User().FindUserByID(context.Background(), identity.UserID)
+			if err == nil {
+				err = h.syncGithubKeys(context.Background(), user, identity.Subject)
+			}
+			if err != nil {
+				h.log.Error(err)
+			}
+		}
+	}
+}
+
+// githubIdentity returns the linked GitHub account, nil when there is none
+func (h *handlerV1) githubIdentity(ctx context.Context, userID string) (*mongodb.Identity, error) {
+	identities, err := h.strg.Identity().GetIdentitiesByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range identities {
+		if identities[i].Provider == GithubProvider {
+			return &identities[i], nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// date format of key expiry in html forms
+const keyExpiryLayout = "2006-01-02"
+
+type keyView struct {
+	ID        string
+	Name      string
+	SSHHash   string
+	LastUsed  string
+	ExpiresAt string
+	Expired   bool
+	Github    bool // synced from GitHub profile
+	Defaults  *mongodb.KeyDefaults
+}
+
+func newKeyView(key mongodb.Keys) keyView {
+	view := keyView{
+		ID:       key.ID.Hex(),
+		Name:     key.Name,
+		SSHHash:  key.SSHHash,
+		Expired:  key.Expired(time.Now()),
+		Github:   key.Source == mongodb.KeySourceGithub,
+		Defaults: key.Defaults,
+	}
+	if lastUsed, err := time.Parse(time.RFC3339, key.LastUsedAt); err == nil {
+		view.LastUsed = fmt.Sprintf("%v from %v", timeAgo(lastUsed), key.LastUsedIP)
+	}
+	if expiresAt, err := time.Parse(time.RFC3339, key.ExpiresAt); err == nil {
+		view.ExpiresAt = expiresAt.Format(keyExpiryLayout)
+	}
+
+	return view
+}
+
+// timeAgo formats the time like "3 days ago"
+func timeAgo(t time.Time) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %v ago", unit)
+		}
+		return fmt.Sprintf("%v %vs ago", n, unit)
+	}
+
+	since := time.Since(t)
+	switch {
+	case since < time.Minute:
+		return "just now"
+	case since < time.Hour:
+		return plural(int(since.Minutes()), "minute")
+	case since < 24*time.Hour:
+		return plural(int(since.Hours()), "hour")
+	default:
+		return plural(int(since.Hours()/24), "day")
+	}
+}
+
+func findKey(user *mongodb.User, id string) *mongodb.Keys {
+	for i := range user.Keys {
+		if user.Keys[i].ID.Hex() == id {
+			return &user.Keys[i]
+		}
+	}
+
+	return nil
+}
+
+func (h *handlerV1) renderEditKey(c *fiber.Ctx, username string, key *mongodb.Keys, errMsg string) error {
+	return c.Render("settings/edit_key", fiber.Map{
+		"username": username,
+		"key":      newKeyView(*key),
+		"error":    errMsg,
+		"links":    UserVerifiedHeader,
+	})
+}
+
+func (h *handlerV1) HandleSettingEditKeyPage(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	key := findKey(user, c.Params("id"))
+	if key == nil {
+		return c.Redirect(c.BaseURL() + "/s/settings/keys")
+	}
+
+	return h.renderEditKey(c, data.Username, key, "")
+}
+
+func (h *handlerV1) HandleSettingEditKey(c *fiber.Ctx) error {
+	payload := struct {
+		Name            string `json:"name"`
+		ExpiresAt       string `json:"expiresAt"`
+		From            string `json:"from"`
+		Save            string `json:"save"`
+		Downloads       string `json:"downloads"`
+		RequirePassword string `json:"requirePassword"`
+	}{}
+	if err := c.BodyParser(&payload); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	data, _ := h.getAuth(c)
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	key := findKey(user, c.Params("id"))
+	if key == nil {
+		return c.Redirect(c.BaseURL() + "/s/settings/keys")
+	}
+
+	update := &mongodb.Keys{
+		ID:   key.ID,
+		Name: strings.TrimSpace(payload.Name),
+	}
+	if update.Name == "" {
+		update.Name = key.Name
+	}
+
+	if payload.ExpiresAt != "" {
+		expiresAt, err := time.Parse(keyExpiryLayout, payload.ExpiresAt)
+		if err != nil {
+			return h.renderEditKey(c, data.Username, key, "Hmm 🤔 The expiry date is not valid!")
+		}
+		// key works until the end of the day
+		update.ExpiresAt = expiresAt.Add(24*time.Hour - time.Second).Format(time.RFC3339)
+	}
+
+	// empty fields mean no default
+	save, err := strconv.Atoi("0" + strings.TrimSpace(payload.Save))
+	if err != nil || save > 60 {
+		return h.renderEditKey(c, data.Username, key, "Hmm 🤔 Default link time must be from 1 to 60 minutes!")
+	}
+	downloads, err := strconv.Atoi("0" + strings.TrimSpace(payload.Downloads))
+	if err != nil || downloads > 100 {
+		return h.renderEditKey(c, data.Username, key, "Hmm 🤔 Default download count must be from 1 to 100!")
+	}
+
+	defaults := &mongodb.KeyDefaults{
+		From:            strings.TrimSpace(payload.From),
+		Save:            save,
+		Downloads:       downloads,
+		RequirePassword: payload.RequirePassword != "",
+	}
+	if *defaults != (mongodb.KeyDefaults{}) {
+		update.Defaults = defaults
+	}
+
+	err = h.strg.User().UpdateKey(context.Background(), data.UserID, update)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/s/settings/keys")
+}
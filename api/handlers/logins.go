@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/oidc"
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+	"github.com/gofiber/fiber/v2"
+)
+
+type identityView struct {
+	ID          string
+	Provider    string
+	DisplayName string
+	Username    string
+	Email       string
+	CreatedAt   string
+	LastUsedAt  string
+}
+
+func (h *handlerV1) HandleSettingGetLogins(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+
+	return h.renderLogins(c, data.UserID, data.Username, "")
+}
+
+func (h *handlerV1) renderLogins(c *fiber.Ctx, userID, username, errMsg string) error {
+	identities, err := h.strg.Identity().GetIdentitiesByUserID(context.Background(), userID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	linked := make(map[string]bool, len(identities))
+	views := make([]identityView, 0, len(identities))
+	for _, identity := range identities {
+		linked[identity.Provider] = true
+		view := identityView{
+			ID:          identity.ID.Hex(),
+			Provider:    identity.Provider,
+			DisplayName: identity.Provider,
+			Username:    identity.Username,
+			Email:       identity.Email,
+			CreatedAt:   identity.CreatedAt,
+			LastUsedAt:  identity.LastUsedAt,
+		}
+		if p, ok := h.providers[identity.Provider]; ok {
+			view.DisplayName = p.DisplayName()
+		}
+		views = append(views, view)
+	}
+
+	available := make([]loginProvider, 0, len(h.loginProviders))
+	for _, p := range h.loginProviders {
+		if !linked[p.Name] {
+			available = append(available, p)
+		}
+	}
+
+	return c.Render("settings/logins", fiber.Map{
+		"username":   username,
+		"identities": views,
+		"available":  available,
+		"error":      errMsg,
+		"links":      UserVerifiedHeader,
+	})
+}
+
+// HandleSettingLinkLogin sends the signed in user to the provider, the account there is linked on callback
+func (h *handlerV1) HandleSettingLinkLogin(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+	provider, ok := h.providers[c.Params("provider")]
+	if !ok {
+		return c.Redirect(c.BaseURL() + "/s/settings/logins")
+	}
+
+	return h.redirectToProvider(c, provider, data.UserID, loginActionLink)
+}
+
+// linkIdentity finishes linking which the user confirmed in settings, the session must still be of the same user
+func (h *handlerV1) linkIdentity(c *fiber.Ctx, provider *oidc.Provider, userID string, claims *oidc.Claims) error {
+	data, _ := h.getAuth(c)
+	if data == nil || data.UserID != userID {
+		return c.Redirect(c.BaseURL() + "/login")
+	}
+
+	err := h.strg.Identity().CreateIdentity(context.Background(), &mongodb.Identity{
+		Provider: provider.Name(),
+		Subject:  claims.Subject,
+		UserID:   userID,
+		Username: claims.Username,
+		Email:    claims.Email,
+	})
+	if errors.Is(err, mongodb.ErrIdentityLinked) {
+		identity, err := h.strg.Identity().FindIdentity(context.Background(), provider.Name(), claims.Subject)
+		if err != nil {
+			h.log.Error(err)
+			return err
+		}
+		if identity.UserID != userID {
+			return h.renderLogins(c, data.UserID, data.Username, "Oops! 😕 This "+provider.DisplayName()+" account is already linked to another account.")
+		}
+		return c.Redirect(c.BaseURL() + "/s/settings/logins")
+	}
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/s/settings/logins")
+}
+
+// HandleSettingUnlinkLogin removes a sign-in method, the last one is kept so the account can still be signed in
+func (h *handlerV1) HandleSettingUnlinkLogin(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+
+	identities, err := h.strg.Identity().GetIdentitiesByUserID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	if len(identities) < 2 {
+		return h.renderLogins(c, data.UserID, data.Username, "Hmm 🤔 You can not remove the only way to sign in!")
+	}
+
+	if err := h.strg.Identity().DeleteIdentity(context.Background(), data.UserID, c.Params("id")); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/s/settings/logins")
+}
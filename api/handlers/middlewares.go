@@ -2,11 +2,28 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
 
+	"github.com/SaidovZohid/swiftsend.it/pkg/domains"
+	"github.com/SaidovZohid/swiftsend.it/pkg/links"
 	"github.com/SaidovZohid/swiftsend.it/pkg/utils"
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// paths which are shared by pages on every hostname and must not be rewritten
+var hostPassthroughPaths = []string{
+	"/assets/",
+	"/events/",
+	"/preview/",
+	"/qr/",
+	"/report/",
+	"/favicon.ico",
+}
+
 func (h *handlerV1) AuthMiddleware(c *fiber.Ctx) error {
 	payload, _ := h.getAuth(c)
 	if payload == nil {
@@ -16,6 +33,113 @@ func (h *handlerV1) AuthMiddleware(c *fiber.Ctx) error {
 	return c.Next()
 }
 
+// HostRouter dispatches <subdomain>., direct. and delete. hostnames of the apex domain and verified custom
+// domains to the path routes, so links work without rewriting them in a reverse proxy.
+// Hostnames of the apex domain are only routed in subdomain links mode.
+func (h *handlerV1) HostRouter(c *fiber.Ctx) error {
+	host := strings.ToLower(c.Hostname())
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	if host == h.links.Host() {
+		return c.Next()
+	}
+
+	path := c.Path()
+	for _, prefix := range hostPassthroughPaths {
+		if strings.HasPrefix(path, prefix) {
+			return c.Next()
+		}
+	}
+	code := strings.Trim(path, "/")
+
+	label, ok := strings.CutSuffix(host, "."+h.links.Host())
+	if !ok {
+		return h.routeCustomDomain(c, host, code)
+	}
+
+	if h.links.Mode() != links.ModeSubdomain {
+		return c.Next()
+	}
+
+	if strings.Contains(label, ".") {
+		return h.renderSubdomainNotFound(c)
+	}
+
+	switch label {
+	case links.Direct, links.Delete, links.Unknown:
+		if code == "" {
+			return c.Redirect(h.links.Site(), fiber.StatusFound)
+		}
+		if label == links.Unknown {
+			c.Path("/download/" + links.Unknown + "/" + code)
+		} else {
+			c.Path("/" + label + "/" + code)
+		}
+		return c.Next()
+	}
+
+	_, err := h.strg.User().FindUserBySubdomain(context.Background(), label)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if ok, err := h.redirectRenamed(c, label, code); ok || err != nil {
+				return err
+			}
+			return h.renderSubdomainNotFound(c)
+		}
+		return err
+	}
+
+	return rewriteToSubdomain(c, label, code)
+}
+
+// routeCustomDomain serves verified custom domain like the subdomain of its owner. Hosts come from any request,
+// so only domain names are looked up and the answers, found or not, are cached for a while.
+func (h *handlerV1) routeCustomDomain(c *fiber.Ctx, host, code string) error {
+	// ip address, localhost or host of some proxy
+	if domain, err := domains.Normalize(host); err != nil || domain != host {
+		return c.Next()
+	}
+
+	subdomain, ok := h.customDomains.get(host, time.Now())
+	if !ok {
+		user, err := h.strg.User().FindUserByCustomDomain(context.Background(), host)
+		switch {
+		case err == nil && user.Subdomain != nil:
+			subdomain = *user.Subdomain
+		case err != nil && !errors.Is(err, mongo.ErrNoDocuments):
+			// the page is served like on any unknown host, the lookup is tried again on the next request
+			h.log.Error(err)
+			return c.Next()
+		}
+		h.customDomains.set(host, subdomain, time.Now())
+	}
+	if subdomain == "" {
+		return c.Next()
+	}
+
+	return rewriteToSubdomain(c, subdomain, code)
+}
+
+func rewriteToSubdomain(c *fiber.Ctx, subdomain, code string) error {
+	if code == "" {
+		c.Path("/domain/" + subdomain)
+	} else {
+		c.Path("/download/" + subdomain + "/" + code)
+	}
+
+	return c.Next()
+}
+
+func (h *handlerV1) renderSubdomainNotFound(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).Render("errors/404", fiber.Map{
+		"what": "Subdomain",
+		"link": h.links.Site(),
+		"text": "The subdomain you are looking for doesn't exist. But don't worry, you can create it and make it your own by clicking the button below! 🚀✨",
+	})
+}
+
 func (h *handlerV1) getAuth(c *fiber.Ctx) (*utils.Payload, string) {
 	cookie := c.Cookies(h.cfg.AuthCookieName)
 	if cookie == "" {
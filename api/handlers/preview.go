@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/compress"
+	"github.com/SaidovZohid/swiftsend.it/sshserver"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	previewMaxSize   = 10 << 20  // files bigger than 10MB are never previewed
+	previewTextLimit = 256 << 10 // text bigger than 256KB is shown as head and tail
+	previewTextLines = 100       // lines shown in head and in tail of a big text
+
+	previewText  = "text"
+	previewImage = "image"
+	previewPDF   = "pdf"
+)
+
+// languages known by highlight.js, matched by file extension
+var previewLanguages = map[string]string{
+	".go":         "go",
+	".js":         "javascript",
+	".mjs":        "javascript",
+	".ts":         "typescript",
+	".py":         "python",
+	".rb":         "ruby",
+	".rs":         "rust",
+	".java":       "java",
+	".kt":         "kotlin",
+	".c":          "c",
+	".h":          "c",
+	".cpp":        "cpp",
+	".hpp":        "cpp",
+	".cs":         "csharp",
+	".php":        "php",
+	".swift":      "swift",
+	".sh":         "bash",
+	".bash":       "bash",
+	".zsh":        "bash",
+	".json":       "json",
+	".yaml":       "yaml",
+	".yml":        "yaml",
+	".toml":       "ini",
+	".ini":        "ini",
+	".env":        "ini",
+	".xml":        "xml",
+	".html":       "xml",
+	".css":        "css",
+	".sql":        "sql",
+	".md":         "markdown",
+	".proto":      "protobuf",
+	".dockerfile": "dockerfile",
+	".log":        "plaintext",
+	".txt":        "plaintext",
+	".csv":        "plaintext",
+}
+
+// svg is left out on purpose, it can carry scripts
+var previewImages = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+}
+
+type filePreview struct {
+	Kind     string
+	Language string
+	Text     string
+	Head     string
+	Tail     string
+	Skipped  int // lines hidden between head and tail
+}
+
+// peekFile returns the uploaded bytes without draining them, so previews do not consume the download.
+// Files which are not found clean by the scanner yet are not returned.
+func peekFile(val sshserver.Tunnel) ([]byte, bool) {
+	if !val.Downloadable() {
+		return nil, false
+	}
+	buf, ok := val.File.W.(*bytes.Buffer)
+	if !ok {
+		return nil, false
+	}
+
+	return buf.Bytes(), true
+}
+
+// previewData returns content of the file for previews, pre-compressed uploads are decoded when they are small enough
+func previewData(val sshserver.Tunnel) ([]byte, bool) {
+	data, ok := peekFile(val)
+	if !ok || val.File.Encoding == "" {
+		return data, ok
+	}
+	if val.File.Encoding != compress.Gzip || val.File.ContentSize > previewMaxSize {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+	if _, err := compress.Gunzip(&buf, data); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+func previewFilename(link string, val sshserver.Tunnel) string {
+	if val.User.Options != nil && val.User.Options.Filename != nil {
+		return *val.User.Options.Filename
+	}
+
+	return link
+}
+
+// detectPreview decides how the file can be shown in the browser, it returns content type for images and pdf
+func detectPreview(filename string, data []byte) (string, string) {
+	if len(data) == 0 || len(data) > previewMaxSize {
+		return "", ""
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if strings.EqualFold(filepath.Base(filename), "dockerfile") {
+		ext = ".dockerfile"
+	}
+
+	if _, ok := previewLanguages[ext]; ok && utf8.Valid(data) {
+		return previewText, "text/plain; charset=utf-8"
+	}
+	if contentType, ok := previewImages[ext]; ok {
+		return previewImage, contentType
+	}
+	if ext == ".pdf" {
+		return previewPDF, "application/pdf"
+	}
+
+	// fall back to content sniffing when the filename says nothing
+	contentType := http.DetectContentType(data)
+	switch {
+	case strings.HasPrefix(contentType, "text/plain") && utf8.Valid(data):
+		return previewText, contentType
+	case contentType == "application/pdf":
+		return previewPDF, contentType
+	}
+	for _, v := range previewImages {
+		if v == contentType {
+			return previewImage, contentType
+		}
+	}
+
+	return "", ""
+}
+
+func buildPreview(filename string, data []byte) *filePreview {
+	kind, _ := detectPreview(filename, data)
+	if kind == "" {
+		return nil
+	}
+
+	preview := &filePreview{Kind: kind}
+	if kind != previewText {
+		return preview
+	}
+
+	preview.Language = previewLanguages[strings.ToLower(filepath.Ext(filename))]
+	if preview.Language == "" {
+		preview.Language = "plaintext"
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if len(data) <= previewTextLimit && len(lines) <= 2*previewTextLines {
+		preview.Text = string(data)
+		return preview
+	}
+
+	// big logs are shown as head and tail
+	if len(lines) > 2*previewTextLines {
+		preview.Head = strings.Join(lines[:previewTextLines], "\n")
+		preview.Tail = strings.Join(lines[len(lines)-previewTextLines:], "\n")
+		preview.Skipped = len(lines) - 2*previewTextLines
+		return preview
+	}
+
+	// few but very long lines, e.g. minified json
+	preview.Head = strings.ToValidUTF8(string(data[:previewTextLimit/2]), "")
+	preview.Tail = strings.ToValidUTF8(string(data[len(data)-previewTextLimit/2:]), "")
+
+	return preview
+}
+
+// HandlePreview serves images and pdf files inline for the download page, it never ends the transfer
+func (h *handlerV1) HandlePreview(c *fiber.Ctx) error {
+	link := c.Params("link")
+	val, ok := h.pipes.Get(link)
+	if !ok {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	data, ok := previewData(val)
+	if !ok || val.User.Options.PasswordProtected() {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	kind, contentType := detectPreview(previewFilename(link, val), data)
+	if kind != previewImage && kind != previewPDF {
+		return c.SendStatus(fiber.StatusUnsupportedMediaType)
+	}
+
+	c.Set("Content-Type", contentType)
+	c.Set("Content-Disposition", "inline")
+	c.Set("X-Content-Type-Options", "nosniff")
+	if kind == previewImage {
+		c.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	}
+
+	return c.Send(data)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/config"
+	"github.com/SaidovZohid/swiftsend.it/pkg/oidc"
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// user has this long to sign in at the provider
+const loginStateTTL = 10 * time.Minute
+
+type loginProvider struct {
+	Name        string
+	DisplayName string
+}
+
+// newProviders builds login providers of the config, providers without client id are left out
+func newProviders(cfg *config.Config) []*oidc.Provider {
+	var providers []*oidc.Provider
+
+	if cfg.Github.ClientID != "" {
+		providers = append(providers, oidc.NewProvider(oidc.Config{
+			Name:         GithubProvider,
+			DisplayName:  "GitHub",
+			ClientID:     cfg.Github.ClientID,
+			ClientSecret: cfg.Github.SecretKey,
+			RedirectURL:  cfg.Github.RedirectURI,
+			Scopes:       []string{"read:user", "user:email"},
+			AuthURL:      "https://github.com/login/oauth/authorize",
+			TokenURL:     "https://github.com/login/oauth/access_token",
+			UserInfoURL:  "https://api.github.com/user",
+			EmailsURL:    "https://api.github.com/user/emails",
+		}, nil))
+	}
+
+	if cfg.Google.ClientID != "" {
+		providers = append(providers, oidc.NewProvider(oidc.Config{
+			Name:         GoogleProvider,
+			DisplayName:  "Google",
+			Issuer:       "https://accounts.google.com",
+			ClientID:     cfg.Google.ClientID,
+			ClientSecret: cfg.Google.SecretKey,
+			RedirectURL:  cfg.Google.RedirectURI,
+			Scopes:       []string{"openid", "email", "profile"},
+		}, nil))
+	}
+
+	for _, p := range cfg.OIDC {
+		if p.ClientID == "" || p.Issuer == "" {
+			continue
+		}
+		providers = append(providers, oidc.NewProvider(oidc.Config{
+			Name:         p.Name,
+			DisplayName:  p.DisplayName,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURI,
+			Scopes:       p.Scopes,
+		}, nil))
+	}
+
+	return providers
+}
+
+// storageStateStore keeps login states in mongodb, so the callback can be served by any instance
+type storageStateStore struct {
+	repo mongodb.OAuthStateI
+}
+
+func (s *storageStateStore) Save(ctx context.Context, key string, state *oidc.State) error {
+	return s.repo.SaveState(ctx, &mongodb.OAuthState{
+		Key:       key,
+		Provider:  state.Provider,
+		Nonce:     state.Nonce,
+		Verifier:  state.Verifier,
+		UserID:    state.UserID,
+		Action:    state.Action,
+		CreatedAt: state.CreatedAt,
+		ExpiresAt: state.CreatedAt.Add(loginStateTTL),
+	})
+}
+
+func (s *storageStateStore) Take(ctx context.Context, key string) (*oidc.State, error) {
+	state, err := s.repo.TakeState(ctx, key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, oidc.ErrStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidc.State{
+		Provider:  state.Provider,
+		Nonce:     state.Nonce,
+		Verifier:  state.Verifier,
+		CreatedAt: state.CreatedAt,
+		UserID:    state.UserID,
+		Action:    state.Action,
+	}, nil
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/skip2/go-qrcode"
+)
+
+// HandleQRCode renders QR code of the download page, so the file can be opened on a phone
+func (h *handlerV1) HandleQRCode(c *fiber.Ctx) error {
+	link := c.Params("link")
+	val, ok := h.pipes.Get(link)
+	if !ok {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	downloadLink := h.links.Download(val.User.Subdomain, link)
+	if val.User.CustomDomain != "" {
+		downloadLink = h.links.Domain(val.User.CustomDomain) + "/" + link
+	}
+
+	png, err := qrcode.Encode(downloadLink, qrcode.Medium, 256)
+	if err != nil {
+		return err
+	}
+
+	c.Set("Content-Type", "image/png")
+	return c.Send(png)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimit answers 429 to ip addresses which send too many requests of the kind
+func (h *handlerV1) RateLimit(kind string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		decision, err := h.limiter.AllowIP(kind, c.IP())
+		if err != nil {
+			// limits being unavailable does not take the site down
+			h.log.Error(err)
+			return c.Next()
+		}
+		if decision.Allowed {
+			return c.Next()
+		}
+
+		wait := decision.RetryAfter.Round(time.Second)
+		if wait < time.Second {
+			wait = time.Second
+		}
+		text := "Too many requests, try again in " + wait.String() + "."
+		if decision.Banned {
+			text = "Too many requests from your ip address, it is blocked for " + wait.String() + "."
+		}
+
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return c.Status(fiber.StatusTooManyRequests).Render("errors/429", fiber.Map{
+			"text": text,
+			"link": h.cfg.BaseURL,
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/SaidovZohid/swiftsend.it/sshserver"
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+	"github.com/gofiber/fiber/v2"
+)
+
+// longer details of reports are cut
+const maxReportDetails = 1000
+
+type reportReason struct {
+	Value string
+	Label string
+}
+
+// reportReasons are offered by the report form of the download page
+var reportReasons = []reportReason{
+	{"spam", "Spam or scam"},
+	{"malware", "Malware or phishing"},
+	{"illegal", "Illegal content"},
+	{"copyright", "Copyright infringement"},
+	{"other", "Something else"},
+}
+
+// HandleReportLink records abuse report of the link and freezes it until admins review it
+func (h *handlerV1) HandleReportLink(c *fiber.Ctx) error {
+	link := c.Params("link")
+	val, ok := h.pipes.Get(link)
+	if !ok {
+		return c.Render("errors/404", fiber.Map{
+			"what": "File",
+			"link": h.cfg.BaseURL,
+			"text": "The provided link is either invalid or has already expired. 🚫🔗 Please ensure you have a valid and up-to-date link. ⏳",
+		})
+	}
+
+	reason := "other"
+	for _, r := range reportReasons {
+		if r.Value == c.FormValue("reason") {
+			reason = r.Value
+		}
+	}
+	details := strings.TrimSpace(c.FormValue("details"))
+	if utf8.RuneCountInString(details) > maxReportDetails {
+		details = string([]rune(details)[:maxReportDetails])
+	}
+
+	report := &mongodb.Report{
+		Link:        link,
+		Reason:      reason,
+		Details:     details,
+		ReporterIP:  c.IP(),
+		Subdomain:   val.User.Subdomain,
+		Fingerprint: val.User.Fingerprint,
+		IPAddress:   val.User.IPAddress,
+	}
+	if val.User.Options != nil && val.User.Options.Filename != nil {
+		report.Filename = *val.User.Options.Filename
+	}
+	if err := h.strg.Report().CreateReport(context.Background(), report); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	// the tunnel is changed under the lock, downloads counted meanwhile are kept
+	h.pipes.Update(link, func(t *sshserver.Tunnel) { t.Frozen = true })
+
+	return c.Render("download/frozen", fiber.Map{
+		"title": "Thank you",
+		"text":  "The link is frozen until our moderators review your report. 🚩",
+		"link":  h.cfg.BaseURL,
+	})
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type sessionView struct {
+	ID        string
+	Device    string
+	IpAddress string
+	Timezone  string
+	LastLogin string
+	CreatedAt string
+	Current   bool
+}
+
+func (h *handlerV1) HandleSettingGetSessions(c *fiber.Ctx) error {
+	data, current := h.getAuth(c)
+
+	sessions, err := h.strg.Session().GetSessionsByUserID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	views := make([]sessionView, 0, len(sessions))
+	for _, s := range sessions {
+		view := sessionView{
+			ID:        s.SessionID.Hex(),
+			Device:    s.Device,
+			IpAddress: s.IpAddress,
+			Timezone:  s.Timezone,
+			LastLogin: s.LastLogin,
+			CreatedAt: s.CreatedAt,
+			Current:   s.SessionID.Hex() == current,
+		}
+		// current session goes first
+		if view.Current {
+			views = append([]sessionView{view}, views...)
+		} else {
+			views = append(views, view)
+		}
+	}
+
+	return c.Render("settings/sessions", fiber.Map{
+		"username": data.Username,
+		"sessions": views,
+		"links":    UserVerifiedHeader,
+	})
+}
+
+// HandleSettingDeleteSession signs out one device of the user, signing out the current one works as logout
+func (h *handlerV1) HandleSettingDeleteSession(c *fiber.Ctx) error {
+	data, current := h.getAuth(c)
+	id := c.Params("id")
+
+	session, err := h.strg.Session().GetSessionByID(context.Background(), id)
+	if err != nil || session.UserID != data.UserID {
+		return c.Redirect(c.BaseURL() + "/s/settings/sessions")
+	}
+
+	if err := h.strg.Session().DeleteSessionByID(context.Background(), id); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	if id == current {
+		h.SetCookie(c, h.cfg.AuthCookieName, "", time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC))
+		return c.Redirect(c.BaseURL() + "/login")
+	}
+
+	return c.Redirect(c.BaseURL() + "/s/settings/sessions")
+}
+
+func (h *handlerV1) HandleSettingDeleteOtherSessions(c *fiber.Ctx) error {
+	data, current := h.getAuth(c)
+
+	if err := h.strg.Session().DeleteSessionsByUserID(context.Background(), data.UserID, current); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/s/settings/sessions")
+}
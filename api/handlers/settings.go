@@ -2,20 +2,27 @@ package handlers
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func (h *handlerV1) HandleSettingGetAccount(c *fiber.Ctx) error {
 	payload, _ := h.getAuth(c)
-	user, err := h.strg.User().FindUserByUsername(context.Background(), payload.Username)
+	user, err := h.strg.User().FindUserByID(context.Background(), payload.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return h.renderAccount(c, user, "")
+}
+
+func (h *handlerV1) renderAccount(c *fiber.Ctx, user *mongodb.User, errMsg string) error {
+	usage, err := h.usageMeter(context.Background(), user)
 	if err != nil {
 		h.log.Error(err)
 		return err
@@ -23,20 +30,20 @@ func (h *handlerV1) HandleSettingGetAccount(c *fiber.Ctx) error {
 
 	if user.Subdomain == nil {
 		return c.Render("settings/account", fiber.Map{
-			"username": payload.Username,
+			"username": user.Username,
+			"error":    errMsg,
+			"usage":    usage,
 			"links":    UserVerifiedHeader,
 		})
 	}
 
-	link := h.cfg.BaseURL + "/domain/" + *user.Subdomain
-	if h.cfg.BaseURL != "http://localhost:3000" {
-		link = "https://" + *user.Subdomain + "." + h.cfg.BaseURL[8:]
-	}
-
 	return c.Render("settings/account", fiber.Map{
-		"username": user.Username,
-		"link":     link,
-		"links":    UserVerifiedHeader,
+		"username":  user.Username,
+		"link":      h.links.Subdomain(*user.Subdomain),
+		"subdomain": *user.Subdomain,
+		"error":     errMsg,
+		"usage":     usage,
+		"links":     UserVerifiedHeader,
 	})
 }
 
@@ -52,53 +59,21 @@ func (h *handlerV1) HandleSettingPostAccount(c *fiber.Ctx) error {
 		return err
 	}
 
-	// TODO: check subdomain is valid and is not in restricted subdomains.
-	payload.Subdomain = strings.ToLower(payload.Subdomain)
-
-	if strings.Contains(payload.Subdomain, ".") {
-		return c.Render("settings/account", fiber.Map{
-			"username":  data.Username,
-			"error":     "Apologies for the inconvenience! 😊 The subdomain you entered is invalid!",
-			"subdomain": payload.Subdomain,
-			"key":       payload.SSHKey,
-			"links":     UserVerifiedHeader,
-		})
-	}
-
-	payload.Subdomain = removeHTTPProtocol(payload.Subdomain)
-
-	for _, v := range engshlishNotAllowedSubdomains {
-		if v == payload.Subdomain {
-			return c.Render("settings/account", fiber.Map{
-				"username":  data.Username,
-				"error":     "Apologies for the inconvenience! 😊 The subdomain you entered is either invalid or restricted by us. Please double-check the subdomain you provided and try again. If you have any questions or need assistance, feel free to reach out to me(support@zohiddev.me). I'am here to help! 🌟",
-				"subdomain": payload.Subdomain,
-				"key":       payload.SSHKey,
-				"links":     UserVerifiedHeader,
-			})
-		}
-	}
-
-	_, err := h.strg.User().FindUserBySubdomain(context.Background(), payload.Subdomain)
-	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+	subdomain, msg, err := h.checkSubdomain(context.Background(), data.UserID, payload.Subdomain)
+	if err != nil {
 		h.log.Error(err)
-		return c.Render("settings/account", fiber.Map{
-			"username":  data.Username,
-			"error":     "Oops! 😊 The subdomain you entered is already in use! Please choose a different subdomain to continue.",
-			"subdomain": payload.Subdomain,
-			"key":       payload.SSHKey,
-			"links":     UserVerifiedHeader,
-		})
+		return err
 	}
-	if err == nil {
+	if msg != "" {
 		return c.Render("settings/account", fiber.Map{
 			"username":  data.Username,
-			"error":     "Oops! 😊 The subdomain you entered is already in use! Please choose a different subdomain to continue.",
+			"error":     msg,
 			"subdomain": payload.Subdomain,
 			"key":       payload.SSHKey,
 			"links":     UserVerifiedHeader,
 		})
 	}
+	payload.Subdomain = subdomain
 
 	_, fingerPrint, err := ExtractPublicKeyAndFingerprint(payload.SSHKey)
 	if err != nil {
@@ -127,34 +102,32 @@ func (h *handlerV1) HandleSettingPostAccount(c *fiber.Ctx) error {
 func (h *handlerV1) HandleSettingGetKeys(c *fiber.Ctx) error {
 	data, _ := h.getAuth(c)
 
-	user, err := h.strg.User().FindUserByUsername(context.Background(), data.Username)
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return h.renderKeys(c, user, "")
+}
+
+func (h *handlerV1) renderKeys(c *fiber.Ctx, user *mongodb.User, errMsg string) error {
+	identity, err := h.githubIdentity(context.Background(), user.Id.Hex())
 	if err != nil {
 		h.log.Error(err)
 		return err
 	}
 
-	keys := make([]struct {
-		ID      string
-		Name    string
-		SSHHash string
-	}, 0)
-	if len(user.Keys) > 0 {
-		for _, v := range user.Keys {
-			keys = append(keys, struct {
-				ID      string
-				Name    string
-				SSHHash string
-			}{
-				ID:      v.ID.Hex(),
-				Name:    v.Name,
-				SSHHash: v.SSHHash,
-			})
-		}
+	keys := make([]keyView, 0, len(user.Keys))
+	for _, v := range user.Keys {
+		keys = append(keys, newKeyView(v))
 	}
 
 	return c.Render("settings/keys", fiber.Map{
-		"username": data.Username,
+		"username": user.Username,
 		"keys":     keys,
+		"github":   identity != nil,
+		"error":    errMsg,
 		"links":    UserVerifiedHeader,
 	})
 }
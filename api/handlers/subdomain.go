@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/domains"
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	subdomainInvalidMsg    = "Apologies for the inconvenience! 😊 The subdomain you entered is invalid! Use up to 63 letters, digits and hyphens, it can not start or end with a hyphen."
+	subdomainRestrictedMsg = "Apologies for the inconvenience! 😊 The subdomain you entered is either invalid or restricted by us. Please double-check the subdomain you provided and try again. If you have any questions or need assistance, feel free to reach out to me(support@zohiddev.me). I'am here to help! 🌟"
+	subdomainInUseMsg      = "Oops! 😊 The subdomain you entered is already in use! Please choose a different subdomain to continue."
+	subdomainHeldMsg       = "Oops! 😊 The subdomain you entered was released recently and is not available yet. Please choose a different subdomain to continue."
+)
+
+// checkSubdomain normalizes the subdomain and returns the reason why the user can not claim it
+func (h *handlerV1) checkSubdomain(ctx context.Context, userID, subdomain string) (string, string, error) {
+	subdomain = strings.ToLower(strings.TrimSpace(removeHTTPProtocol(subdomain)))
+	if !domains.ValidLabel(subdomain) {
+		return subdomain, subdomainInvalidMsg, nil
+	}
+
+	reserved, err := h.strg.Subdomain().IsReserved(ctx, subdomain)
+	if err != nil {
+		return subdomain, "", err
+	}
+	if reserved {
+		return subdomain, subdomainRestrictedMsg, nil
+	}
+
+	_, err = h.strg.User().FindUserBySubdomain(ctx, subdomain)
+	if err == nil {
+		return subdomain, subdomainInUseMsg, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return subdomain, "", err
+	}
+
+	// old owner can take it back during cooling-off period
+	released, err := h.strg.Subdomain().GetReleased(ctx, subdomain)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return subdomain, "", err
+	}
+	if released != nil && released.UserID != userID {
+		return subdomain, subdomainHeldMsg, nil
+	}
+
+	return subdomain, "", nil
+}
+
+func (h *handlerV1) HandleSettingRenameSubdomain(c *fiber.Ctx) error {
+	payload := struct {
+		Subdomain string `json:"subdomain"`
+	}{}
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	data, _ := h.getAuth(c)
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	if user.Subdomain == nil {
+		return c.Redirect(c.BaseURL() + "/s/settings/account")
+	}
+
+	if changedAt, err := time.Parse(time.RFC3339, user.SubdomainChangedAt); err == nil {
+		if next := changedAt.Add(h.cfg.SubdomainCoolingOff); time.Now().Before(next) {
+			return h.renderAccount(c, user, fmt.Sprintf("You have renamed your subdomain recently 🙂 You can rename it again after %v.", next.Format("January 2, 2006")))
+		}
+	}
+
+	subdomain, msg, err := h.checkSubdomain(context.Background(), data.UserID, payload.Subdomain)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	if subdomain == *user.Subdomain {
+		return c.Redirect(c.BaseURL() + "/s/settings/account")
+	}
+	if msg != "" {
+		return h.renderAccount(c, user, msg)
+	}
+
+	if err := h.strg.User().ChangeSubdomain(context.Background(), data.UserID, subdomain); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	// links with old subdomain keep working during cooling-off period
+	now := time.Now()
+	err = h.strg.Subdomain().Release(context.Background(), &mongodb.Released{
+		Subdomain:    *user.Subdomain,
+		UserID:       data.UserID,
+		NewSubdomain: subdomain,
+		ReleasedAt:   now,
+		AvailableAt:  now.Add(h.cfg.SubdomainCoolingOff),
+	})
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/s/settings/account")
+}
+
+func (h *handlerV1) HandleSettingReleaseSubdomain(c *fiber.Ctx) error {
+	data, _ := h.getAuth(c)
+	user, err := h.strg.User().FindUserByID(context.Background(), data.UserID)
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+	if user.Subdomain == nil {
+		return c.Redirect(c.BaseURL() + "/s/settings/account")
+	}
+
+	if err := h.strg.User().ReleaseSubdomain(context.Background(), data.UserID); err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	now := time.Now()
+	err = h.strg.Subdomain().Release(context.Background(), &mongodb.Released{
+		Subdomain:   *user.Subdomain,
+		UserID:      data.UserID,
+		ReleasedAt:  now,
+		AvailableAt: now.Add(h.cfg.SubdomainCoolingOff),
+	})
+	if err != nil {
+		h.log.Error(err)
+		return err
+	}
+
+	return c.Redirect(c.BaseURL() + "/s/settings/account")
+}
+
+// redirectRenamed sends visitors of renamed subdomain to the new one, empty link is for the subdomain page
+func (h *handlerV1) redirectRenamed(c *fiber.Ctx, subdomain, link string) (bool, error) {
+	released, err := h.strg.Subdomain().GetReleased(context.Background(), subdomain)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+	if released.NewSubdomain == "" {
+		return false, nil
+	}
+
+	if link == "" {
+		return true, c.Redirect(h.links.Subdomain(released.NewSubdomain), fiber.StatusMovedPermanently)
+	}
+
+	return true, c.Redirect(h.links.Download(released.NewSubdomain, link), fiber.StatusMovedPermanently)
+}
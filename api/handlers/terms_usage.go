@@ -17,17 +17,23 @@ func (h *handlerV1) HandleTermsPage(c *fiber.Ctx) error {
 }
 
 func (h *handlerV1) HandleHowToUsePage(c *fiber.Ctx) error {
+	page := fiber.Map{
+		"links":    UserNotVerifiedHeader,
+		"base_url": h.cfg.BaseURL,
+	}
+	// fingerprints let users check the server on the first connection
+	if h.hostKeys != nil {
+		page["host_keys"] = h.hostKeys.Fingerprints()
+		if rotateAt := h.hostKeys.RotateAt(); !rotateAt.IsZero() {
+			page["rotate_at"] = rotateAt
+		}
+	}
+
 	data, _ := h.getAuth(c)
-	if data == nil {
-		return c.Render("how_to_use/index", fiber.Map{
-			"links":    UserNotVerifiedHeader,
-			"base_url": h.cfg.BaseURL,
-		})
+	if data != nil {
+		page["username"] = data.Username
+		page["links"] = UserVerifiedHeader
 	}
 
-	return c.Render("how_to_use/index", fiber.Map{
-		"username": data.Username,
-		"links":    UserVerifiedHeader,
-		"base_url": h.cfg.BaseURL,
-	})
+	return c.Render("how_to_use/index", page)
 }
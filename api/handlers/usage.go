@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/quota"
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+)
+
+// usageView is the usage meter in account settings, limits are empty when they are unlimited
+type usageView struct {
+	Plan           string
+	Transfers      int64
+	DailyTransfers int64
+	Bytes          int64
+	MonthlyBytes   int64
+	Rejected       int64
+	Sent           string
+	Volume         string
+	MaxFileSize    string
+	MaxExpiry      string
+}
+
+func (h *handlerV1) usageMeter(ctx context.Context, user *mongodb.User) (*usageView, error) {
+	usage, err := h.quotas.Usage(ctx, quota.Sender{
+		UserID:   user.Id.Hex(),
+		Plan:     user.Plan,
+		Verified: user.Subdomain != nil,
+	}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	plan := usage.Plan
+	plan.MaxFileSize = usage.FileSizeLimit()
+	view := &usageView{
+		Plan:           plan.Name,
+		Transfers:      usage.Transfers,
+		DailyTransfers: plan.DailyTransfers,
+		Bytes:          usage.Bytes,
+		MonthlyBytes:   plan.MonthlyBytes,
+		Rejected:       usage.Rejected,
+		Sent:           quota.FormatBytes(usage.Bytes),
+	}
+	if plan.MonthlyBytes > 0 {
+		view.Volume = quota.FormatBytes(plan.MonthlyBytes)
+	}
+	if plan.MaxFileSize > 0 {
+		view.MaxFileSize = quota.FormatBytes(plan.MaxFileSize)
+	}
+	if plan.MaxExpiry > 0 {
+		view.MaxExpiry = fmt.Sprintf("%v minutes", int(plan.MaxExpiry.Minutes()))
+	}
+
+	return view, nil
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/SaidovZohid/swiftsend.it/config"
+	"github.com/SaidovZohid/swiftsend.it/pkg/certs"
+	"github.com/SaidovZohid/swiftsend.it/pkg/links"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Listen serves the app on plain http, or on https with built-in tls when it is configured
+func Listen(app *fiber.App, opt *RoutetOptions) error {
+	cfg := opt.Cfg
+
+	switch cfg.TLS.Mode {
+	case "", config.TLSModeOff:
+		return app.Listen(cfg.HttpPort)
+	case config.TLSModeStatic:
+		tlsConfig, err := certs.Static(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		if cfg.TLS.RedirectHTTP {
+			go listenRedirect(opt, redirectToHTTPS(cfg.TLS.HttpsPort))
+		}
+		return listenTLS(app, cfg.TLS.HttpsPort, tlsConfig)
+	case config.TLSModeACME:
+		manager, err := newCertManager(opt)
+		if err != nil {
+			return err
+		}
+		// http-01 challenges come to http port, other requests are redirected to https
+		var fallback http.Handler
+		if cfg.TLS.RedirectHTTP {
+			fallback = redirectToHTTPS(cfg.TLS.HttpsPort)
+		}
+		go listenRedirect(opt, manager.HTTPHandler(fallback))
+		return listenTLS(app, cfg.TLS.HttpsPort, manager.TLSConfig())
+	}
+
+	return fmt.Errorf("unknown tls mode %q", cfg.TLS.Mode)
+}
+
+func listenTLS(app *fiber.App, addr string, tlsConfig *tls.Config) error {
+	ln, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	return app.Listener(ln)
+}
+
+func listenRedirect(opt *RoutetOptions, handler http.Handler) {
+	if err := http.ListenAndServe(opt.Cfg.HttpPort, handler); err != nil {
+		opt.Log.Fatal("error while listening http port for redirects:", err)
+	}
+}
+
+func redirectToHTTPS(httpsPort string) http.Handler {
+	port := strings.TrimPrefix(httpsPort, ":")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+func newCertManager(opt *RoutetOptions) (*certs.Manager, error) {
+	acme := opt.Cfg.TLS.ACME
+
+	options := &certs.Options{
+		Email:        acme.Email,
+		DirectoryURL: acme.DirectoryURL,
+		CacheDir:     acme.CacheDir,
+		Host:         opt.Links.Host(),
+		HostPolicy:   hostPolicy(opt),
+	}
+
+	if acme.CARootFile != "" {
+		pem, err := os.ReadFile(acme.CARootFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in ACME_CA_ROOT")
+		}
+		options.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	}
+
+	if acme.DNSProvider != "" {
+		provider, err := certs.NewDNSProvider(acme.DNSProvider)
+		if err != nil {
+			return nil, err
+		}
+		options.DNSProvider = provider
+	}
+	// the wildcard certificate is obtained in background, the static one is served until then
+	if opt.Cfg.TLS.CertFile != "" && opt.Cfg.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opt.Cfg.TLS.CertFile, opt.Cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		options.Certificate = &cert
+	}
+
+	return certs.New(context.Background(), options)
+}
+
+// hostPolicy allows certificates only for hosts the app serves, so nobody can make us ask for random ones
+func hostPolicy(opt *RoutetOptions) func(ctx context.Context, host string) error {
+	apex := opt.Links.Host()
+	return func(ctx context.Context, host string) error {
+		if host == apex {
+			return nil
+		}
+
+		label, ok := strings.CutSuffix(host, "."+apex)
+		if !ok {
+			_, err := opt.Strg.User().FindUserByCustomDomain(ctx, host)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return fmt.Errorf("host %q is not allowed", host)
+			}
+			return err
+		}
+
+		if opt.Links.Mode() != links.ModeSubdomain || strings.Contains(label, ".") {
+			return fmt.Errorf("host %q is not allowed", host)
+		}
+		switch label {
+		case links.Direct, links.Delete, links.Unknown:
+			return nil
+		}
+
+		_, err := opt.Strg.User().FindUserBySubdomain(ctx, label)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("host %q is not allowed", host)
+		}
+		return err
+	}
+}
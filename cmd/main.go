@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/SaidovZohid/swiftsend.it/api"
-	"github.com/SaidovZohid/swiftsend.it/api/handlers"
 	"github.com/SaidovZohid/swiftsend.it/config"
+	"github.com/SaidovZohid/swiftsend.it/pkg/blob"
+	"github.com/SaidovZohid/swiftsend.it/pkg/envelope"
+	"github.com/SaidovZohid/swiftsend.it/pkg/hostkeys"
+	"github.com/SaidovZohid/swiftsend.it/pkg/links"
 	"github.com/SaidovZohid/swiftsend.it/pkg/logger"
 	"github.com/SaidovZohid/swiftsend.it/pkg/mongodb"
+	"github.com/SaidovZohid/swiftsend.it/pkg/quota"
+	"github.com/SaidovZohid/swiftsend.it/pkg/ratelimit"
+	"github.com/SaidovZohid/swiftsend.it/pkg/scanner"
 	"github.com/SaidovZohid/swiftsend.it/sshserver"
 	"github.com/SaidovZohid/swiftsend.it/storage"
-	gossh "golang.org/x/crypto/ssh"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func main() {
@@ -28,42 +41,232 @@ func main() {
 	log.Info("database initialized")
 
 	// for tunneling throw ssh and http
-	pipes := make(map[string]sshserver.Tunnel)
+	pipes := sshserver.NewTunnels()
 
 	strg := storage.NewStorage(database)
 
-	app := api.New(&api.RoutetOptions{
-		Cfg:   &cfg,
-		Log:   log,
-		Strg:  strg,
-		Pipes: pipes,
+	if err := strg.Subdomain().SeedReserved(context.Background()); err != nil {
+		log.Fatal("error while seeding reserved subdomains:", err)
+	}
+	if err := strg.Identity().CreateIndexes(context.Background()); err != nil {
+		log.Fatal("error while creating identity indexes:", err)
+	}
+	if err := strg.OAuthState().CreateIndexes(context.Background()); err != nil {
+		log.Fatal("error while creating oauth state indexes:", err)
+	}
+	if err := strg.Usage().CreateIndexes(context.Background()); err != nil {
+		log.Fatal("error while creating usage indexes:", err)
+	}
+	if os.Getenv("ADMIN_USERNAMES") != "" {
+		log.Warn("ADMIN_USERNAMES is not used anymore, list admins in ADMINS by user id or provider:subject")
+	}
+	adminIDs, unknown, err := resolveAdmins(&cfg, strg)
+	if err != nil {
+		log.Fatal("error while reading ADMINS:", err)
+	}
+	for _, admin := range unknown {
+		log.Warn("admin ", admin, " has not signed in yet, the role is given on the next start after the first login")
+	}
+	if err := strg.User().SyncAdmins(context.Background(), adminIDs); err != nil {
+		log.Fatal("error while syncing admin roles:", err)
+	}
+
+	lb := links.New(&links.Options{
+		Scheme: cfg.Links.Scheme,
+		Host:   cfg.Links.Host,
+		Port:   cfg.Links.Port,
+		Mode:   cfg.Links.Mode,
 	})
 
+	limiter, err := newLimiter(&cfg)
+	if err != nil {
+		log.Fatal("error while connecting to redis:", err)
+	}
+	quotas := newQuota(&cfg, strg)
+	scan, err := newScanner(&cfg)
+	if err != nil {
+		log.Fatal("error while loading scan denylist:", err)
+	}
+	blobs, err := newBlobStore(&cfg)
+	if err != nil {
+		log.Fatal("error while loading master keys:", err)
+	}
+
+	// host keys of the ssh server, key of ENCRYPTED_PRIVATE_KEY becomes the key of its type so clients keep trusting it
+	if cfg.EncryptSecretKey == "" {
+		log.Fatal("ENCRYPT_SECRET_KEY is not set, ssh host keys are stored encrypted with it")
+	}
+	hostKeyStore := hostkeys.NewStore(cfg.HostKeys.Dir, []byte(cfg.EncryptSecretKey))
+	if cfg.EncryptedPrivateKey != "" {
+		decoded, err := base64.StdEncoding.DecodeString(cfg.EncryptedPrivateKey)
+		if err != nil {
+			log.Fatal("Decoding error:", err)
+		}
+
+		// decrypt the encrypted private key
+		b, err := envelope.Open(decoded, []byte(cfg.EncryptSecretKey))
+		if err != nil {
+			log.Fatal("error while decrypting ENCRYPTED_PRIVATE_KEY with ENCRYPT_SECRET_KEY:", err)
+		}
+		if envelope.IsLegacy(decoded) {
+			log.Warn("ENCRYPTED_PRIVATE_KEY is in the old unauthenticated format, upgrade it with \"go run ./pkg/encrypt/main.go reencrypt\"")
+		}
+
+		imported, err := hostKeyStore.Import(b)
+		if err != nil {
+			log.Fatal("error while importing ENCRYPTED_PRIVATE_KEY:", err)
+		}
+		if imported {
+			log.Info("ENCRYPTED_PRIVATE_KEY is saved to ", cfg.HostKeys.Dir, ", it can be removed from the environment")
+		}
+	}
+	hostKeys, err := hostKeyStore.Load()
+	if err != nil {
+		log.Fatal("error while loading ssh host keys:", err)
+	}
+	if rotateAt := hostKeys.RotateAt(); !rotateAt.IsZero() {
+		log.Info("ssh host keys are rotated at ", rotateAt)
+	}
+
+	routeOptions := &api.RoutetOptions{
+		Cfg:      &cfg,
+		Log:      log,
+		Strg:     strg,
+		Pipes:    pipes,
+		Links:    lb,
+		Limiter:  limiter,
+		Quota:    quotas,
+		Blobs:    blobs,
+		HostKeys: hostKeys,
+	}
+	app := api.New(routeOptions)
+
 	// run htpp port in goroutine
 	go func() {
-		if err = app.Listen(cfg.HttpPort); err != nil {
+		if err := api.Listen(app, routeOptions); err != nil {
 			log.Fatal("error while listening http port:", err)
 		}
 	}()
 
-	// Decrypt the data
-	decoded, err := base64.StdEncoding.DecodeString(cfg.EncryptedPrivateKey)
-	if err != nil {
-		log.Fatal("Decoding error:", err)
+	// listen and serve ssh
+	log.Fatal(sshserver.ListenAndServe(hostKeys, &cfg, pipes, strg, lb, limiter, quotas, scan, blobs))
+}
+
+// resolveAdmins finds ids of the users listed in ADMINS, provider:subject entries which are not linked to a user yet
+// are returned as unknown
+func resolveAdmins(cfg *config.Config, strg storage.StorageI) ([]string, []string, error) {
+	var ids, unknown []string
+	for _, admin := range cfg.Admins {
+		provider, subject, ok := strings.Cut(admin, ":")
+		if !ok {
+			if !primitive.IsValidObjectID(admin) {
+				return nil, nil, fmt.Errorf("%q is neither a user id nor provider:subject", admin)
+			}
+			ids = append(ids, admin)
+			continue
+		}
+
+		identity, err := strg.Identity().FindIdentity(context.Background(), strings.ToLower(provider), subject)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			unknown = append(unknown, admin)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, identity.UserID)
 	}
 
-	// decrypt the encrypted private key
-	b, err := handlers.Decrypt(decoded, []byte(cfg.EncryptSecretKey))
-	if err != nil {
-		log.Error(err)
-		return
+	return ids, unknown, nil
+}
+
+// newLimiter keeps limits in memory of the process, or in redis when they are shared by several instances
+func newLimiter(cfg *config.Config) (*ratelimit.Limiter, error) {
+	var store ratelimit.Store
+	if cfg.RateLimit.Store == "redis" {
+		rdb := redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		if err := rdb.Ping(context.Background()).Err(); err != nil {
+			return nil, err
+		}
+		store = storage.NewInMemoryStorage(rdb)
 	}
 
-	privateKey, err := gossh.ParsePrivateKey(b)
-	if err != nil {
-		log.Fatal("Faild to parse private key:", err)
+	limit := func(l config.LimiterConfig) ratelimit.Limit {
+		return ratelimit.Limit{RPS: l.RPS, Burst: l.Burst, TTL: l.TTL}
 	}
 
-	// listen and serve ssh
-	log.Fatal(sshserver.ListenAndServe(privateKey, &cfg, pipes, strg))
+	return ratelimit.New(&ratelimit.Options{
+		Store: store,
+		Limits: map[string]ratelimit.Limit{
+			ratelimit.SSHPerIP:  limit(cfg.RateLimit.SSHPerIP),
+			ratelimit.SSHPerKey: limit(cfg.RateLimit.SSHPerKey),
+			ratelimit.Uploads:   limit(cfg.RateLimit.Uploads),
+			ratelimit.Direct:    limit(cfg.RateLimit.Direct),
+			ratelimit.Delete:    limit(cfg.RateLimit.Delete),
+			ratelimit.Login:     limit(cfg.RateLimit.Login),
+			ratelimit.Report:    limit(cfg.RateLimit.Report),
+		},
+		Strikes: limit(cfg.RateLimit.Strikes),
+		BanFor:  cfg.RateLimit.BanFor,
+	}), nil
+}
+
+// newQuota counts usage of senders in mongodb
+func newQuota(cfg *config.Config, strg storage.StorageI) *quota.Quota {
+	plans := make([]quota.Plan, 0, len(cfg.Quota.Plans))
+	for _, p := range cfg.Quota.Plans {
+		plans = append(plans, quota.Plan{
+			Name:           p.Name,
+			DailyTransfers: p.DailyTransfers,
+			MaxFileSize:    p.MaxFileSize,
+			MonthlyBytes:   p.MonthlyBytes,
+			MaxExpiry:      p.MaxExpiry,
+		})
+	}
+
+	return quota.New(&quota.Options{
+		Store:         strg.Usage(),
+		Plans:         plans,
+		Anonymous:     cfg.Quota.Anonymous,
+		Registered:    cfg.Quota.Registered,
+		Verified:      cfg.Quota.Verified,
+		MaxUploadSize: cfg.Quota.MaxUploadSize,
+	})
+}
+
+// newScanner checks uploads with the hash denylist first and then with clamd, it is nil when none is configured
+func newScanner(cfg *config.Config) (scanner.Scanner, error) {
+	var scanners []scanner.Scanner
+	if cfg.Scan.DenylistFile != "" {
+		denylist, err := scanner.LoadDenylist(cfg.Scan.DenylistFile)
+		if err != nil {
+			return nil, err
+		}
+		scanners = append(scanners, denylist)
+	}
+	if cfg.Scan.ClamdAddr != "" {
+		scanners = append(scanners, scanner.NewClamd(cfg.Scan.ClamdAddr))
+	}
+
+	return scanner.Chain(scanners...), nil
+}
+
+// newBlobStore keeps quarantined files encrypted with the master key, it is nil when they are not kept
+func newBlobStore(cfg *config.Config) (*blob.Store, error) {
+	if cfg.Scan.QuarantineDir == "" {
+		return nil, nil
+	}
+	if cfg.Encryption.MasterKey == "" {
+		return nil, errors.New("SCAN_QUARANTINE_DIR needs MASTER_KEY, files are stored encrypted")
+	}
+
+	keys, err := blob.NewKeyring(cfg.Encryption.MasterKeyID, cfg.Encryption.Keys())
+	if err != nil {
+		return nil, err
+	}
+	return blob.NewStore(cfg.Scan.QuarantineDir, keys), nil
 }
@@ -1,12 +1,13 @@
 package config
 
 import (
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
 type Config struct {
@@ -22,16 +23,81 @@ type Config struct {
 	EncryptedPrivateKey string
 	EncryptSecretKey    string
 	LocationInfoKey     string
+	Links               Links
+	TLS                 TLS
+	// old subdomain keeps redirecting and can not be claimed by others during this period
+	SubdomainCoolingOff time.Duration
+	// users who get admin role on start, by user id or by provider:subject of their login, e.g. github:583231.
+	// The role is taken from everyone else.
+	Admins []string
+	// OpenID Connect providers like Keycloak, users sign in at /login/<name>
+	OIDC      []OIDCProvider
+	RateLimit RateLimit
+	Redis     Redis
+	// X-Forwarded-For is trusted only from these proxies, client ip is the address of the connection without them
+	TrustedProxies []string
+	Quota          Quota
+	Scan           Scan
+	// uploads get BLAKE2b-512 checksum next to SHA-256
+	ChecksumBLAKE2b bool
+	Encryption      Encryption
+	HostKeys        HostKeys
+}
+
+const (
+	TLSModeOff    = "off"
+	TLSModeStatic = "static"
+	TLSModeACME   = "acme"
+)
+
+type TLS struct {
+	Mode         string // off, static or acme
+	HttpsPort    string
+	RedirectHTTP bool // with tls on, HTTP_PORT redirects to https
+	CertFile     string
+	KeyFile      string
+	ACME         ACME
+}
+
+type ACME struct {
+	Email        string
+	DirectoryURL string
+	CacheDir     string
+	DNSProvider  string // dns-01 provider for the wildcard certificate, empty to get certificates per host
+	CARootFile   string // CA of ACME directory when it is not publicly trusted, e.g. Pebble
+}
+
+type Links struct {
+	Scheme string
+	Host   string
+	Port   string
+	Mode   string
 }
 
 type Github struct {
 	ClientID    string
 	SecretKey   string
 	RedirectURI string
+	// public keys of the user are synced from GitHub on every login
+	SyncKeys bool
+	// GitHub keys of all users are synced periodically, zero turns it off
+	KeySyncInterval time.Duration
 }
 
 type Google struct {
-	Conf *oauth2.Config
+	ClientID    string
+	SecretKey   string
+	RedirectURI string
+}
+
+type OIDCProvider struct {
+	Name         string
+	DisplayName  string
+	Issuer       string // discovery document is fetched from <issuer>/.well-known/openid-configuration
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Scopes       []string
 }
 
 type MongoDB struct {
@@ -40,18 +106,202 @@ type MongoDB struct {
 	Password string
 }
 
+// LimiterConfig is a token bucket, Burst requests at once and RPS more every second, idle buckets are forgotten after TTL
 type LimiterConfig struct {
-	RPS   int
+	RPS   float64
 	Burst int
 	TTL   time.Duration
 }
 
+type RateLimit struct {
+	Store     string // memory or redis
+	SSHPerIP  LimiterConfig
+	SSHPerKey LimiterConfig
+	Uploads   LimiterConfig // per ip address
+	Direct    LimiterConfig
+	Delete    LimiterConfig
+	Login     LimiterConfig
+	Report    LimiterConfig // abuse reports of links
+	// ip address which hits limits more often than Strikes is banned for BanFor
+	Strikes LimiterConfig
+	BanFor  time.Duration
+}
+
+// Plan limits uploads of senders, zero limit means unlimited
+type Plan struct {
+	Name           string
+	DailyTransfers int64
+	MaxFileSize    int64 // bytes
+	MonthlyBytes   int64
+	MaxExpiry      time.Duration
+}
+
+type Quota struct {
+	Plans []Plan
+	// names of plans given to senders by default
+	Anonymous  string // senders without account
+	Registered string // users without subdomain
+	Verified   string // users with subdomain
+	// MaxUploadSize limits files of all plans, uploads are kept in memory
+	MaxUploadSize int64
+}
+
+// defaultPlans are used for plans which are not configured
+var defaultPlans = map[string]Plan{
+	"anonymous":  {DailyTransfers: 3, MaxFileSize: 100 << 20, MonthlyBytes: 1 << 30, MaxExpiry: 15 * time.Minute},
+	"registered": {DailyTransfers: 10, MaxFileSize: 500 << 20, MonthlyBytes: 5 << 30, MaxExpiry: 30 * time.Minute},
+	"verified":   {MaxFileSize: 1 << 30, MonthlyBytes: 50 << 30, MaxExpiry: time.Hour},
+}
+
+// Scan checks uploads before they can be downloaded, scanning is off when no scanner is configured
+type Scan struct {
+	ClamdAddr     string // tcp address like localhost:3310 or path of unix socket
+	DenylistFile  string // sha256 hashes of files which are never handed out, one per line
+	Timeout       time.Duration
+	QuarantineDir string // infected files are kept here for review, they are dropped when it is empty
+	FailOpen      bool   // files which could not be scanned are handed out
+}
+
+// HostKeys of the ssh server are kept in Dir sealed with ENCRYPT_SECRET_KEY, missing ones are generated on start
+type HostKeys struct {
+	Dir string
+	// next keys are advertised to clients this long before they replace the current ones
+	RotationWindow time.Duration
+}
+
+// Encryption keeps files stored on disk encrypted, data key of every file is wrapped by the master key
+type Encryption struct {
+	MasterKeyID string
+	MasterKey   string // 32 bytes like ENCRYPT_SECRET_KEY
+	// previous master keys by their ids, files wrapped by them are read until they are rewrapped
+	OldMasterKeys map[string]string
+}
+
+// Keys returns the master key and the old ones by their ids
+func (e Encryption) Keys() map[string][]byte {
+	keys := make(map[string][]byte, len(e.OldMasterKeys)+1)
+	for id, key := range e.OldMasterKeys {
+		keys[id] = []byte(key)
+	}
+	keys[e.MasterKeyID] = []byte(e.MasterKey)
+
+	return keys
+}
+
+type Redis struct {
+	Addr     string
+	Password string
+	DB       int
+}
+
 func Load() Config {
 	godotenv.Load()
 
 	conf := viper.New()
 	conf.AutomaticEnv()
 
+	// links are built from BASE_URL unless they are configured one by one
+	links := Links{
+		Scheme: conf.GetString("LINK_SCHEME"),
+		Host:   conf.GetString("LINK_HOST"),
+		Port:   conf.GetString("LINK_PORT"),
+		Mode:   conf.GetString("LINK_MODE"),
+	}
+	if u, err := url.Parse(conf.GetString("BASE_URL")); err == nil {
+		if links.Scheme == "" {
+			links.Scheme = u.Scheme
+		}
+		if links.Host == "" {
+			links.Host = u.Hostname()
+			if links.Port == "" {
+				links.Port = u.Port()
+			}
+		}
+	}
+
+	coolingOff := conf.GetDuration("SUBDOMAIN_COOLING_OFF")
+	if coolingOff == 0 {
+		coolingOff = 30 * 24 * time.Hour
+	}
+
+	var admins []string
+	for _, admin := range strings.Split(conf.GetString("ADMINS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" {
+			admins = append(admins, admin)
+		}
+	}
+
+	var oidcProviders []OIDCProvider
+	for _, name := range strings.Split(conf.GetString("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		scopes := strings.Fields(conf.GetString(prefix + "SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+		oidcProviders = append(oidcProviders, OIDCProvider{
+			Name:         name,
+			DisplayName:  conf.GetString(prefix + "DISPLAY_NAME"),
+			Issuer:       conf.GetString(prefix + "ISSUER"),
+			ClientID:     conf.GetString(prefix + "CLIENT_ID"),
+			ClientSecret: conf.GetString(prefix + "CLIENT_SECRET"),
+			RedirectURI:  conf.GetString(prefix + "REDIRECT_URI"),
+			Scopes:       scopes,
+		})
+	}
+
+	var trustedProxies []string
+	for _, proxy := range strings.Split(conf.GetString("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+
+	var plans []Plan
+	planNames := conf.GetString("PLANS")
+	if planNames == "" {
+		planNames = "anonymous,registered,verified"
+	}
+	for _, name := range strings.Split(planNames, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			plans = append(plans, plan(conf, name))
+		}
+	}
+
+	maxUploadSize := int64(conf.GetSizeInBytes("MAX_UPLOAD_SIZE"))
+	if !conf.IsSet("MAX_UPLOAD_SIZE") {
+		maxUploadSize = 2 << 30
+	}
+
+	scanTimeout := conf.GetDuration("SCAN_TIMEOUT")
+	if scanTimeout == 0 {
+		scanTimeout = time.Minute
+	}
+
+	oldMasterKeys := map[string]string{}
+	for _, pair := range strings.Split(conf.GetString("OLD_MASTER_KEYS"), ",") {
+		if id, key, ok := strings.Cut(strings.TrimSpace(pair), ":"); ok {
+			oldMasterKeys[strings.ToLower(strings.TrimSpace(id))] = key
+		}
+	}
+
+	hostKeyDir := conf.GetString("SSH_HOST_KEY_DIR")
+	if hostKeyDir == "" {
+		hostKeyDir = "hostkeys"
+	}
+	rotationWindow := conf.GetDuration("SSH_HOST_KEY_ROTATION_WINDOW")
+	if rotationWindow == 0 {
+		rotationWindow = 30 * 24 * time.Hour
+	}
+
+	banFor := conf.GetDuration("RATE_LIMIT_BAN_FOR")
+	if !conf.IsSet("RATE_LIMIT_BAN_FOR") {
+		banFor = time.Hour
+	}
+
 	return Config{
 		BaseURL:     conf.GetString("BASE_URL"),
 		TimerForSSH: conf.GetDuration("TIMER_FOR_SSH"),
@@ -63,26 +313,148 @@ func Load() Config {
 			Password: conf.GetString("MONGODB_PASSWORD"),
 		},
 		Github: Github{
-			ClientID:    conf.GetString("GITHUB_CLIENT_ID"),
-			SecretKey:   conf.GetString("GITHUB_SECRET_KEY"),
-			RedirectURI: conf.GetString("GITHUB_REDIRECT_URI"),
+			ClientID:        conf.GetString("GITHUB_CLIENT_ID"),
+			SecretKey:       conf.GetString("GITHUB_SECRET_KEY"),
+			RedirectURI:     conf.GetString("GITHUB_REDIRECT_URI"),
+			SyncKeys:        conf.GetBool("GITHUB_SYNC_KEYS"),
+			KeySyncInterval: conf.GetDuration("GITHUB_KEY_SYNC_INTERVAL"),
 		},
 		Google: Google{
-			Conf: &oauth2.Config{
-				ClientID:     conf.GetString("GOOGLE_CLIENT_ID"),
-				ClientSecret: conf.GetString("GOOGLE_SECRET_KEY"),
-				RedirectURL:  conf.GetString("GOOGLE_REDIRECT_URI"),
-				Scopes: []string{
-					"https://www.googleapis.com/auth/userinfo.email",
-					"https://www.googleapis.com/auth/userinfo.profile",
-				},
-				Endpoint: google.Endpoint,
-			},
+			ClientID:    conf.GetString("GOOGLE_CLIENT_ID"),
+			SecretKey:   conf.GetString("GOOGLE_SECRET_KEY"),
+			RedirectURI: conf.GetString("GOOGLE_REDIRECT_URI"),
+		},
+		OIDC: oidcProviders,
+		RateLimit: RateLimit{
+			Store:     conf.GetString("RATE_LIMIT_STORE"),
+			SSHPerIP:  limiter(conf, "RATE_LIMIT_SSH_IP", "30/1m"),
+			SSHPerKey: limiter(conf, "RATE_LIMIT_SSH_KEY", "30/1m"),
+			Uploads:   limiter(conf, "RATE_LIMIT_UPLOADS", "100/1h"),
+			Direct:    limiter(conf, "RATE_LIMIT_DIRECT", "60/1m"),
+			Delete:    limiter(conf, "RATE_LIMIT_DELETE", "30/1m"),
+			Login:     limiter(conf, "RATE_LIMIT_LOGIN", "20/10m"),
+			Report:    limiter(conf, "RATE_LIMIT_REPORT", "5/1h"),
+			Strikes:   limiter(conf, "RATE_LIMIT_STRIKES", "20/10m"),
+			BanFor:    banFor,
+		},
+		TrustedProxies:  trustedProxies,
+		ChecksumBLAKE2b: conf.GetBool("CHECKSUM_BLAKE2B"),
+		Encryption: Encryption{
+			MasterKeyID:   stringOr(conf, "MASTER_KEY_ID", "1"),
+			MasterKey:     conf.GetString("MASTER_KEY"),
+			OldMasterKeys: oldMasterKeys,
+		},
+		HostKeys: HostKeys{
+			Dir:            hostKeyDir,
+			RotationWindow: rotationWindow,
+		},
+		Scan: Scan{
+			ClamdAddr:     conf.GetString("CLAMD_ADDR"),
+			DenylistFile:  conf.GetString("SCAN_DENYLIST_FILE"),
+			Timeout:       scanTimeout,
+			QuarantineDir: conf.GetString("SCAN_QUARANTINE_DIR"),
+			FailOpen:      conf.GetBool("SCAN_FAIL_OPEN"),
+		},
+		Quota: Quota{
+			Plans:         plans,
+			Anonymous:     stringOr(conf, "QUOTA_ANONYMOUS_PLAN", "anonymous"),
+			Registered:    stringOr(conf, "QUOTA_REGISTERED_PLAN", "registered"),
+			Verified:      stringOr(conf, "QUOTA_VERIFIED_PLAN", "verified"),
+			MaxUploadSize: maxUploadSize,
+		},
+		Redis: Redis{
+			Addr:     conf.GetString("REDIS_ADDR"),
+			Password: conf.GetString("REDIS_PASSWORD"),
+			DB:       conf.GetInt("REDIS_DB"),
 		},
 		AuthCookieName:      conf.GetString("AUTH_COOKIE_NAME"),
 		TokenSecretKey:      conf.GetString("TOKEN_SECRET_KEY"),
 		EncryptedPrivateKey: conf.GetString("ENCRYPTED_PRIVATE_KEY"),
 		EncryptSecretKey:    conf.GetString("ENCRYPT_SECRET_KEY"),
 		LocationInfoKey:     conf.GetString("LOCATION_INFO_KEY"),
+		Links:               links,
+		SubdomainCoolingOff: coolingOff,
+		Admins:              admins,
+		TLS: TLS{
+			Mode:         conf.GetString("TLS_MODE"),
+			HttpsPort:    conf.GetString("HTTPS_PORT"),
+			RedirectHTTP: conf.GetBool("TLS_REDIRECT_HTTP"),
+			CertFile:     conf.GetString("TLS_CERT_FILE"),
+			KeyFile:      conf.GetString("TLS_KEY_FILE"),
+			ACME: ACME{
+				Email:        conf.GetString("ACME_EMAIL"),
+				DirectoryURL: conf.GetString("ACME_DIRECTORY_URL"),
+				CacheDir:     conf.GetString("ACME_CACHE_DIR"),
+				DNSProvider:  conf.GetString("ACME_DNS_PROVIDER"),
+				CARootFile:   conf.GetString("ACME_CA_ROOT"),
+			},
+		},
 	}
 }
+
+// plan reads limits of the plan from PLAN_<NAME>_* keys, sizes are like 100MB. Limits which are not set
+// are taken from the default plan with the same name.
+func plan(conf *viper.Viper, name string) Plan {
+	p := defaultPlans[name]
+	p.Name = name
+
+	prefix := "PLAN_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+	if conf.IsSet(prefix + "DAILY_TRANSFERS") {
+		p.DailyTransfers = conf.GetInt64(prefix + "DAILY_TRANSFERS")
+	}
+	if conf.IsSet(prefix + "MAX_FILE_SIZE") {
+		p.MaxFileSize = int64(conf.GetSizeInBytes(prefix + "MAX_FILE_SIZE"))
+	}
+	if conf.IsSet(prefix + "MONTHLY_BYTES") {
+		p.MonthlyBytes = int64(conf.GetSizeInBytes(prefix + "MONTHLY_BYTES"))
+	}
+	if conf.IsSet(prefix + "MAX_EXPIRY") {
+		p.MaxExpiry = conf.GetDuration(prefix + "MAX_EXPIRY")
+	}
+
+	return p
+}
+
+func stringOr(conf *viper.Viper, key, def string) string {
+	if value := strings.ToLower(strings.TrimSpace(conf.GetString(key))); value != "" {
+		return value
+	}
+
+	return def
+}
+
+// limiter reads limits like "30/1m", 30 requests at once and 30 more every minute. "0" turns the limit off,
+// values which can not be read fall back to the default
+func limiter(conf *viper.Viper, key, def string) LimiterConfig {
+	value := strings.TrimSpace(conf.GetString(key))
+	if value == "0" {
+		return LimiterConfig{}
+	}
+	if l, ok := parseLimiter(value); ok {
+		return l
+	}
+
+	l, _ := parseLimiter(def)
+	return l
+}
+
+func parseLimiter(value string) (LimiterConfig, bool) {
+	count, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return LimiterConfig{}, false
+	}
+	burst, err := strconv.Atoi(count)
+	if err != nil || burst <= 0 {
+		return LimiterConfig{}, false
+	}
+	every, err := time.ParseDuration(period)
+	if err != nil || every <= 0 {
+		return LimiterConfig{}, false
+	}
+
+	return LimiterConfig{
+		RPS:   float64(burst) / every.Seconds(),
+		Burst: burst,
+		TTL:   every,
+	}, true
+}
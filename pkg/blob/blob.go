@@ -0,0 +1,449 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Blob layout:
+//
+//	"JTFB" | version | chunk size (uint32) | key id length | key id | wrapped key length (uint16) | wrapped key | chunks
+//
+// Every chunk is chunk size bytes of the file sealed with AES-256-GCM under the data key of the file, only the last
+// one is shorter. The nonce is the index of the chunk with a flag on the last one, so chunks can not be reordered
+// and the blob can not be cut. Chunks do not depend on the wrapped key, rewrapping rewrites the header only.
+const (
+	magic            = "JTFB"
+	version          = 1
+	DefaultChunkSize = 64 << 10
+	KeySize          = 32 // AES-256
+)
+
+var (
+	ErrFormat     = errors.New("blob: not an encrypted blob")
+	ErrUnknownKey = errors.New("blob: master key of the blob is not known")
+	ErrCorrupted  = errors.New("blob: blob is corrupted or was changed")
+)
+
+// Keyring holds master keys by their ids. Data keys of new blobs are wrapped with the current key, older keys are
+// only used to read blobs which were not rewrapped yet.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring checks that master keys are 32 bytes and the current one is among them
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{
+		current: current,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("blob: master key id %q must be 1 to 255 bytes", id)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("blob: master key %q must be %d bytes", id, KeySize)
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+	}
+	if _, ok := k.keys[current]; !ok {
+		return nil, fmt.Errorf("blob: current master key %q is not in the keyring", current)
+	}
+
+	return k, nil
+}
+
+// Current returns id of the master key new data keys are wrapped with
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+func (k *Keyring) wrap(dataKey []byte) ([]byte, error) {
+	kek := k.keys[k.current]
+	nonce := make([]byte, kek.NonceSize(), kek.NonceSize()+len(dataKey)+kek.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return kek.Seal(nonce, nonce, dataKey, []byte(k.current)), nil
+}
+
+func (k *Keyring) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if len(wrapped) < kek.NonceSize() {
+		return nil, ErrCorrupted
+	}
+
+	dataKey, err := kek.Open(nil, wrapped[:kek.NonceSize()], wrapped[kek.NonceSize():], []byte(keyID))
+	if err != nil || len(dataKey) != KeySize {
+		return nil, ErrCorrupted
+	}
+	return dataKey, nil
+}
+
+type header struct {
+	chunkSize int64
+	keyID     string
+	wrapped   []byte
+}
+
+func (h *header) marshal() []byte {
+	var b bytes.Buffer
+	b.Write(h.prefix())
+	b.WriteByte(byte(len(h.keyID)))
+	b.WriteString(h.keyID)
+	binary.Write(&b, binary.BigEndian, uint16(len(h.wrapped)))
+	b.Write(h.wrapped)
+
+	return b.Bytes()
+}
+
+// prefix is authenticated with every chunk, a blob can not be read with another chunk size
+func (h *header) prefix() []byte {
+	b := make([]byte, 0, len(magic)+5)
+	b = append(b, magic...)
+	b = append(b, version)
+	return binary.BigEndian.AppendUint32(b, uint32(h.chunkSize))
+}
+
+// readHeader parses the header at the start of the blob and returns its length
+func readHeader(r io.ReaderAt) (*header, int64, error) {
+	fixed := make([]byte, len(magic)+6)
+	if err := readFull(r, fixed, 0); err != nil {
+		return nil, 0, ErrFormat
+	}
+	if string(fixed[:len(magic)]) != magic || fixed[len(magic)] != version {
+		return nil, 0, ErrFormat
+	}
+
+	h := &header{chunkSize: int64(binary.BigEndian.Uint32(fixed[len(magic)+1:]))}
+	if h.chunkSize == 0 {
+		return nil, 0, ErrFormat
+	}
+	off := int64(len(fixed))
+
+	keyID := make([]byte, int(fixed[len(fixed)-1])+2)
+	if err := readFull(r, keyID, off); err != nil {
+		return nil, 0, ErrFormat
+	}
+	h.keyID = string(keyID[:len(keyID)-2])
+	off += int64(len(keyID))
+
+	h.wrapped = make([]byte, binary.BigEndian.Uint16(keyID[len(keyID)-2:]))
+	if err := readFull(r, h.wrapped, off); err != nil {
+		return nil, 0, ErrFormat
+	}
+
+	return h, off + int64(len(h.wrapped)), nil
+}
+
+// Writer encrypts everything written to it into a blob, it must be closed to write the last chunk
+type Writer struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	ad    []byte
+	buf   []byte
+	index uint64
+	err   error
+}
+
+// NewWriter writes the header of a new blob with a fresh data key into w
+func NewWriter(w io.Writer, keys *Keyring) (*Writer, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := keys.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &header{chunkSize: DefaultChunkSize, keyID: keys.Current(), wrapped: wrapped}
+	if _, err := w.Write(h.marshal()); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		w:    w,
+		aead: aead,
+		ad:   h.prefix(),
+		buf:  make([]byte, 0, DefaultChunkSize),
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n := 0
+	for len(p) > 0 {
+		// a full chunk is sealed only when more data comes, the last chunk must be sealed as the last one
+		if len(w.buf) == cap(w.buf) {
+			if w.err = w.seal(false); w.err != nil {
+				return n, w.err
+			}
+		}
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+
+	return n, nil
+}
+
+// Close seals the last chunk, the underlying writer is not closed
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.seal(true)
+	if w.err != nil {
+		return w.err
+	}
+
+	w.err = errors.New("blob: writer is closed")
+	return nil
+}
+
+func (w *Writer) seal(last bool) error {
+	chunk := w.aead.Seal(nil, chunkNonce(w.index, last), w.buf, w.ad)
+	w.buf = w.buf[:0]
+	w.index++
+
+	_, err := w.w.Write(chunk)
+	return err
+}
+
+// Reader decrypts the blob, chunks are decrypted only when bytes in them are read, so ranges of large blobs are
+// cheap to read
+type Reader struct {
+	r         io.ReaderAt
+	aead      cipher.AEAD
+	ad        []byte
+	keyID     string
+	chunkSize int64
+	start     int64 // where chunks start
+	chunks    int64
+	lastSize  int64 // sealed size of the last chunk
+	size      int64
+	pos       int64
+
+	cached      []byte // the chunk Read is in
+	cachedIndex int64
+}
+
+// NewReader opens the blob of the size, the data key is unwrapped with the master key the blob names
+func NewReader(r io.ReaderAt, size int64, keys *Keyring) (*Reader, error) {
+	h, start, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := keys.unwrap(h.keyID, h.wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealedChunk := h.chunkSize + int64(aead.Overhead())
+	body := size - start
+	if body < int64(aead.Overhead()) {
+		return nil, ErrCorrupted
+	}
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	lastSize := body - (chunks-1)*sealedChunk
+	if lastSize < int64(aead.Overhead()) {
+		return nil, ErrCorrupted
+	}
+
+	reader := &Reader{
+		r:           r,
+		aead:        aead,
+		ad:          h.prefix(),
+		keyID:       h.keyID,
+		chunkSize:   h.chunkSize,
+		start:       start,
+		chunks:      chunks,
+		lastSize:    lastSize,
+		size:        body - chunks*int64(aead.Overhead()),
+		cachedIndex: -1,
+	}
+	// the size is trusted only when the last chunk is really the last one, cut blobs fail here
+	if _, err := reader.chunk(chunks - 1); err != nil {
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// Size returns size of the decrypted file
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// KeyID returns id of the master key the data key is wrapped with
+func (r *Reader) KeyID() string {
+	return r.keyID
+}
+
+// ReadAt decrypts the chunks which hold the range, it is safe for concurrent use
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("blob: negative offset")
+	}
+
+	n := 0
+	for n < len(p) && off < r.size {
+		index := off / r.chunkSize
+		chunk, err := r.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], chunk[off-index*r.chunkSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+
+	index := r.pos / r.chunkSize
+	if index != r.cachedIndex {
+		chunk, err := r.chunk(index)
+		if err != nil {
+			return 0, err
+		}
+		r.cached, r.cachedIndex = chunk, index
+	}
+
+	n := copy(p, r.cached[r.pos-index*r.chunkSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("blob: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("blob: negative position")
+	}
+
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *Reader) chunk(index int64) ([]byte, error) {
+	sealedChunk := r.chunkSize + int64(r.aead.Overhead())
+	sealed := make([]byte, sealedChunk)
+	last := index == r.chunks-1
+	if last {
+		sealed = sealed[:r.lastSize]
+	}
+	if err := readFull(r.r, sealed, r.start+index*sealedChunk); err != nil {
+		return nil, err
+	}
+
+	chunk, err := r.aead.Open(sealed[:0], chunkNonce(uint64(index), last), sealed, r.ad)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return chunk, nil
+}
+
+// Rewrap copies the blob into w with its data key wrapped by the current master key, chunks are copied as they are.
+// It returns false without writing when the blob is wrapped by the current key already.
+func Rewrap(w io.Writer, r io.ReaderAt, size int64, keys *Keyring) (bool, error) {
+	h, start, err := readHeader(r)
+	if err != nil {
+		return false, err
+	}
+	if h.keyID == keys.Current() {
+		return false, nil
+	}
+
+	dataKey, err := keys.unwrap(h.keyID, h.wrapped)
+	if err != nil {
+		return false, err
+	}
+	if h.wrapped, err = keys.wrap(dataKey); err != nil {
+		return false, err
+	}
+	h.keyID = keys.Current()
+
+	if _, err := w.Write(h.marshal()); err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(r, start, size-start)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// readFull reads len(p) bytes at off, end of the file right after them is not an error
+func readFull(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[11] = 1
+	}
+
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,164 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrName = errors.New("blob: invalid blob name")
+
+// errUnchanged keeps the old blob when it is wrapped by the current key already
+var errUnchanged = errors.New("blob: unchanged")
+
+// Store keeps encrypted blobs as files in a directory
+type Store struct {
+	dir  string
+	keys *Keyring
+}
+
+// File is an opened blob, it must be closed
+type File struct {
+	*Reader
+	f *os.File
+}
+
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
+func NewStore(dir string, keys *Keyring) *Store {
+	return &Store{
+		dir:  dir,
+		keys: keys,
+	}
+}
+
+// Put encrypts data into the blob with the name, the blob appears only once it is written completely
+func (s *Store) Put(name string, data []byte) error {
+	if !validName(name) {
+		return ErrName
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+
+	return s.replace(name, func(tmp *os.File) error {
+		w, err := NewWriter(tmp, s.keys)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+}
+
+// Open opens the blob for reading
+func (s *Store) Open(name string) (*File, error) {
+	if !validName(name) {
+		return nil, ErrName
+	}
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r, err := NewReader(f, info.Size(), s.keys)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &File{Reader: r, f: f}, nil
+}
+
+// RewrapAll wraps data keys of all blobs with the current master key and returns how many blobs were rewrapped.
+// Blobs which fail are skipped, the first error is returned after all of them are tried.
+func (s *Store) RewrapAll() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		rewrapped int
+		firstErr  error
+	)
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !validName(e.Name()) {
+			continue
+		}
+		ok, err := s.rewrap(e.Name())
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("blob: rewrapping %s: %w", e.Name(), err)
+			}
+			continue
+		}
+		if ok {
+			rewrapped++
+		}
+	}
+
+	return rewrapped, firstErr
+}
+
+func (s *Store) rewrap(name string) (bool, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	err = s.replace(name, func(tmp *os.File) error {
+		rewrapped, err := Rewrap(tmp, f, info.Size(), s.keys)
+		if err == nil && !rewrapped {
+			return errUnchanged
+		}
+		return err
+	})
+	if errors.Is(err, errUnchanged) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// replace writes the blob into a temporary file and moves it over the old one, readers never see half of it
+func (s *Store) replace(name string, write func(tmp *os.File) error) error {
+	tmp, err := os.CreateTemp(s.dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+// validName keeps blobs inside the directory, names of temporary files start with a dot
+func validName(name string) bool {
+	return name != "" && name == filepath.Base(name) && !strings.HasPrefix(name, ".")
+}
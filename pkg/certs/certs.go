@@ -0,0 +1,172 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type Options struct {
+	Email        string
+	DirectoryURL string // Let's Encrypt when it is empty
+	CacheDir     string // certificates and account keys are cached here
+	Host         string // apex host, e.g. jtf.zohiddev.me
+	// HostPolicy allows hosts which get on-demand certificates with http-01 or tls-alpn-01
+	HostPolicy autocert.HostPolicy
+	// DNSProvider is optional, with it host and *.host get one wildcard certificate with dns-01
+	DNSProvider DNSProvider
+	// Certificate is optional, it is served for host and *.host until the wildcard certificate is obtained
+	Certificate *tls.Certificate
+	// HTTPClient talks to ACME directory, set it to trust the CA of a test server such as Pebble
+	HTTPClient *http.Client
+}
+
+// Manager gives certificates to the TLS listener and answers http-01 challenges
+type Manager struct {
+	host     string
+	autocert *autocert.Manager
+	wildcard *wildcardManager
+	fallback *tls.Certificate
+}
+
+// New creates manager, when dns provider is set the wildcard certificate is obtained in background
+func New(ctx context.Context, opt *Options) (*Manager, error) {
+	if opt.CacheDir == "" {
+		return nil, errors.New("certs: cache dir is required")
+	}
+	if opt.DirectoryURL == "" {
+		opt.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	cache := autocert.DirCache(opt.CacheDir)
+
+	m := &Manager{
+		host:     strings.ToLower(opt.Host),
+		fallback: opt.Certificate,
+		autocert: &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      cache,
+			HostPolicy: opt.HostPolicy,
+			Email:      opt.Email,
+			Client: &acme.Client{
+				DirectoryURL: opt.DirectoryURL,
+				HTTPClient:   opt.HTTPClient,
+			},
+		},
+	}
+
+	if opt.DNSProvider != nil {
+		key, err := accountKey(ctx, cache, "wildcard_account+key")
+		if err != nil {
+			return nil, err
+		}
+
+		m.wildcard = &wildcardManager{
+			client: &acme.Client{
+				Key:          key,
+				DirectoryURL: opt.DirectoryURL,
+				HTTPClient:   opt.HTTPClient,
+			},
+			email:    opt.Email,
+			host:     m.host,
+			cache:    cache,
+			provider: opt.DNSProvider,
+			lookup:   authoritativeLookup{},
+
+			propagationTimeout:  propagationTimeout,
+			propagationInterval: propagationInterval,
+		}
+		m.wildcard.start(ctx)
+	}
+
+	return m, nil
+}
+
+// TLSConfig returns config for the https listener
+func (m *Manager) TLSConfig() *tls.Config {
+	cfg := m.autocert.TLSConfig()
+	cfg.GetCertificate = m.getCertificate
+
+	return cfg
+}
+
+// HTTPHandler answers http-01 challenges and passes other requests to fallback
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.autocert.HTTPHandler(fallback)
+}
+
+func (m *Manager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if m.wildcard != nil && m.coveredByWildcard(hello.ServerName) {
+		if cert := m.wildcard.certificate(); cert != nil {
+			return cert, nil
+		}
+		if m.fallback != nil {
+			return m.fallback, nil
+		}
+	}
+
+	return m.autocert.GetCertificate(hello)
+}
+
+// wildcard certificate covers the apex and one level of subdomains
+func (m *Manager) coveredByWildcard(serverName string) bool {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if name == m.host {
+		return true
+	}
+
+	label, ok := strings.CutSuffix(name, "."+m.host)
+
+	return ok && label != "" && !strings.Contains(label, ".")
+}
+
+// Static loads TLS config from certificate and key files
+func Static(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+func accountKey(ctx context.Context, cache autocert.Cache, name string) (crypto.Signer, error) {
+	data, err := cache.Get(ctx, name)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("certs: invalid account key in cache")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.Put(ctx, name, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,665 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// fakeACME is a small RFC 8555 directory. It does not check signatures of requests, validate checks challenges
+// with the key authorization of the account.
+type fakeACME struct {
+	t        *testing.T
+	srv      *httptest.Server
+	validate func(typ, domain, token, keyAuth string) error
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu       sync.Mutex
+	accounts map[string]crypto.PublicKey
+	orders   map[string]*fakeOrder
+	authzs   map[string]*fakeAuthz
+	certs    map[string][]byte
+}
+
+type fakeOrder struct {
+	Status         string         `json:"status"`
+	Identifiers    []acme.AuthzID `json:"identifiers"`
+	Authorizations []string       `json:"authorizations"`
+	Finalize       string         `json:"finalize"`
+	Certificate    string         `json:"certificate,omitempty"`
+
+	authzs []*fakeAuthz
+}
+
+type fakeAuthz struct {
+	Identifier acme.AuthzID     `json:"identifier"`
+	Status     string           `json:"status"`
+	Wildcard   bool             `json:"wildcard,omitempty"`
+	Challenges []*fakeChallenge `json:"challenges"`
+}
+
+type fakeChallenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+func newFakeACME(t *testing.T, challengeType string, validate func(typ, domain, token, keyAuth string) error) *fakeACME {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake acme ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeACME{
+		t:        t,
+		validate: validate,
+		caKey:    caKey,
+		caCert:   caCert,
+		accounts: make(map[string]crypto.PublicKey),
+		orders:   make(map[string]*fakeOrder),
+		authzs:   make(map[string]*fakeAuthz),
+		certs:    make(map[string][]byte),
+	}
+	f.srv = httptest.NewServer(f.handler(challengeType))
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+func (f *fakeACME) directoryURL() string {
+	return f.srv.URL + "/directory"
+}
+
+func (f *fakeACME) handler(challengeType string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", func(w http.ResponseWriter, r *http.Request) {
+		f.reply(w, http.StatusOK, map[string]string{
+			"newNonce":   f.srv.URL + "/nonce",
+			"newAccount": f.srv.URL + "/account",
+			"newOrder":   f.srv.URL + "/order",
+			"revokeCert": f.srv.URL + "/revoke",
+			"keyChange":  f.srv.URL + "/key-change",
+		})
+	})
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", nonce())
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/account", func(w http.ResponseWriter, r *http.Request) {
+		protected, _, err := readJWS(r)
+		if err != nil || protected.JWK == nil {
+			f.problem(w, "malformed", "account request needs jwk")
+			return
+		}
+		pub, err := protected.JWK.publicKey()
+		if err != nil {
+			f.problem(w, "badPublicKey", err.Error())
+			return
+		}
+
+		f.mu.Lock()
+		url := fmt.Sprintf("%s/account/%d", f.srv.URL, len(f.accounts)+1)
+		f.accounts[url] = pub
+		f.mu.Unlock()
+
+		w.Header().Set("Location", url)
+		f.reply(w, http.StatusCreated, map[string]string{"status": "valid"})
+	})
+	mux.HandleFunc("/order", func(w http.ResponseWriter, r *http.Request) {
+		_, payload, err := readJWS(r)
+		var req struct {
+			Identifiers []acme.AuthzID `json:"identifiers"`
+		}
+		if err == nil {
+			err = json.Unmarshal(payload, &req)
+		}
+		if err != nil {
+			f.problem(w, "malformed", "bad order")
+			return
+		}
+
+		f.mu.Lock()
+		id := len(f.orders) + 1
+		order := &fakeOrder{
+			Status:      acme.StatusPending,
+			Identifiers: req.Identifiers,
+			Finalize:    fmt.Sprintf("%s/finalize/%d", f.srv.URL, id),
+		}
+		for i, ident := range req.Identifiers {
+			authzURL := fmt.Sprintf("%s/authz/%d-%d", f.srv.URL, id, i)
+			authz := &fakeAuthz{
+				Identifier: acme.AuthzID{Type: "dns", Value: strings.TrimPrefix(ident.Value, "*.")},
+				Status:     acme.StatusPending,
+				Wildcard:   strings.HasPrefix(ident.Value, "*."),
+				Challenges: []*fakeChallenge{{
+					Type:   challengeType,
+					URL:    fmt.Sprintf("%s/challenge/%d-%d", f.srv.URL, id, i),
+					Token:  nonce(),
+					Status: acme.StatusPending,
+				}},
+			}
+			f.authzs[authzURL] = authz
+			order.Authorizations = append(order.Authorizations, authzURL)
+			order.authzs = append(order.authzs, authz)
+		}
+		orderURL := fmt.Sprintf("%s/orders/%d", f.srv.URL, id)
+		f.orders[orderURL] = order
+		f.mu.Unlock()
+
+		w.Header().Set("Location", orderURL)
+		f.replyOrder(w, http.StatusCreated, order)
+	})
+	mux.HandleFunc("/orders/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		order, ok := f.orders[f.srv.URL+r.URL.Path]
+		f.mu.Unlock()
+		if !ok {
+			f.problem(w, "malformed", "no such order")
+			return
+		}
+		w.Header().Set("Location", f.srv.URL+r.URL.Path)
+		f.replyOrder(w, http.StatusOK, order)
+	})
+	mux.HandleFunc("/authz/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		authz, ok := f.authzs[f.srv.URL+r.URL.Path]
+		if !ok {
+			f.problem(w, "malformed", "no such authorization")
+			return
+		}
+		f.reply(w, http.StatusOK, authz)
+	})
+	mux.HandleFunc("/challenge/", func(w http.ResponseWriter, r *http.Request) {
+		protected, _, err := readJWS(r)
+		if err != nil {
+			f.problem(w, "malformed", err.Error())
+			return
+		}
+
+		f.mu.Lock()
+		pub := f.accounts[protected.KID]
+		var (
+			authz *fakeAuthz
+			chal  *fakeChallenge
+		)
+		for _, z := range f.authzs {
+			for _, c := range z.Challenges {
+				if c.URL == f.srv.URL+r.URL.Path {
+					authz, chal = z, c
+				}
+			}
+		}
+		f.mu.Unlock()
+		if chal == nil || pub == nil {
+			f.problem(w, "malformed", "no such challenge or account")
+			return
+		}
+
+		thumbprint, err := acme.JWKThumbprint(pub)
+		if err != nil {
+			f.problem(w, "serverInternal", err.Error())
+			return
+		}
+		// challenges are validated right away, the client sees the result on its first poll
+		status := acme.StatusValid
+		if err := f.validate(chal.Type, authz.Identifier.Value, chal.Token, chal.Token+"."+thumbprint); err != nil {
+			f.t.Logf("%s challenge of %s failed: %v", chal.Type, authz.Identifier.Value, err)
+			status = acme.StatusInvalid
+		}
+
+		f.mu.Lock()
+		chal.Status, authz.Status = status, status
+		f.reply(w, http.StatusOK, chal)
+		f.mu.Unlock()
+	})
+	mux.HandleFunc("/finalize/", func(w http.ResponseWriter, r *http.Request) {
+		_, payload, err := readJWS(r)
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		if err == nil {
+			err = json.Unmarshal(payload, &req)
+		}
+		der, err2 := base64.RawURLEncoding.DecodeString(req.CSR)
+		if err != nil || err2 != nil {
+			f.problem(w, "malformed", "bad csr")
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			f.problem(w, "badCSR", err.Error())
+			return
+		}
+
+		orderURL := strings.Replace(f.srv.URL+r.URL.Path, "/finalize/", "/orders/", 1)
+		f.mu.Lock()
+		order, ok := f.orders[orderURL]
+		f.mu.Unlock()
+		if !ok || f.orderStatus(order) != acme.StatusReady {
+			f.problem(w, "orderNotReady", "order is not ready")
+			return
+		}
+
+		leaf, err := f.issue(csr)
+		if err != nil {
+			f.problem(w, "serverInternal", err.Error())
+			return
+		}
+
+		f.mu.Lock()
+		certURL := strings.Replace(orderURL, "/orders/", "/cert/", 1)
+		f.certs[certURL] = leaf
+		order.Certificate = certURL
+		f.mu.Unlock()
+
+		w.Header().Set("Location", orderURL)
+		f.replyOrder(w, http.StatusOK, order)
+	})
+	mux.HandleFunc("/cert/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		leaf, ok := f.certs[f.srv.URL+r.URL.Path]
+		f.mu.Unlock()
+		if !ok {
+			f.problem(w, "malformed", "no such certificate")
+			return
+		}
+
+		w.Header().Set("Replay-Nonce", nonce())
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: leaf})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: f.caCert.Raw})
+	})
+
+	return mux
+}
+
+// orderStatus is ready once all authorizations are valid, valid once the certificate is issued
+func (f *fakeACME) orderStatus(order *fakeOrder) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if order.Certificate != "" {
+		return acme.StatusValid
+	}
+	status := acme.StatusReady
+	for _, authz := range order.authzs {
+		switch authz.Status {
+		case acme.StatusInvalid:
+			return acme.StatusInvalid
+		case acme.StatusPending:
+			status = acme.StatusPending
+		}
+	}
+
+	return status
+}
+
+func (f *fakeACME) replyOrder(w http.ResponseWriter, status int, order *fakeOrder) {
+	order.Status = f.orderStatus(order)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reply(w, status, order)
+}
+
+func (f *fakeACME) issue(csr *x509.CertificateRequest) ([]byte, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	return x509.CreateCertificate(rand.Reader, template, f.caCert, csr.PublicKey, f.caKey)
+}
+
+func (f *fakeACME) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Replay-Nonce", nonce())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeACME) problem(w http.ResponseWriter, typ, detail string) {
+	w.Header().Set("Replay-Nonce", nonce())
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"type":   "urn:ietf:params:acme:error:" + typ,
+		"detail": detail,
+	})
+}
+
+type jwsProtected struct {
+	KID string  `json:"kid"`
+	JWK *jwkKey `json:"jwk"`
+}
+
+type jwkKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jwkKey) publicKey() (crypto.PublicKey, error) {
+	if k.Kty != "EC" || k.Crv != "P-256" {
+		return nil, errors.New("only P-256 account keys are supported")
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+func readJWS(r *http.Request) (*jwsProtected, []byte, error) {
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, nil, err
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, nil, err
+	}
+	var protected jwsProtected
+	if err := json.Unmarshal(header, &protected); err != nil {
+		return nil, nil, err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &protected, payload, nil
+}
+
+func nonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestManagerHTTP01(t *testing.T) {
+	var web *httptest.Server
+	ca := newFakeACME(t, "http-01", func(typ, domain, token, keyAuth string) error {
+		req, err := http.NewRequest(http.MethodGet, web.URL+"/.well-known/acme-challenge/"+token, nil)
+		if err != nil {
+			return err
+		}
+		req.Host = domain
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != keyAuth {
+			return fmt.Errorf("got %s %q", resp.Status, body)
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m, err := New(ctx, &Options{
+		DirectoryURL: ca.directoryURL(),
+		CacheDir:     t.TempDir(),
+		Host:         "jtf.test",
+		HostPolicy:   autocert.HostWhitelist("jtf.test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// http-01 is tried only when the challenges are answered
+	web = httptest.NewServer(m.HTTPHandler(nil))
+	defer web.Close()
+
+	cert, err := m.TLSConfig().GetCertificate(&tls.ClientHelloInfo{
+		ServerName:        "jtf.test",
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		CipherSuites:      []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SupportedVersions: []uint16{tls.VersionTLS12},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Leaf.VerifyHostname("jtf.test"); err != nil {
+		t.Errorf("certificate is not for the host: %v", err)
+	}
+
+	if _, err := m.TLSConfig().GetCertificate(&tls.ClientHelloInfo{ServerName: "other.test"}); err == nil {
+		t.Error("certificate was given to a host which is not allowed")
+	}
+}
+
+// fakeDNS keeps records published by the provider, nameservers serve a record only after it was asked for
+// visibleAfter times, like a zone which takes time to reach all nameservers
+type fakeDNS struct {
+	visibleAfter int
+
+	mu      sync.Mutex
+	records map[string]map[string]bool
+	asked   map[string]int
+}
+
+func newFakeDNS(visibleAfter int) *fakeDNS {
+	return &fakeDNS{
+		visibleAfter: visibleAfter,
+		records:      make(map[string]map[string]bool),
+		asked:        make(map[string]int),
+	}
+}
+
+func (d *fakeDNS) Present(ctx context.Context, fqdn, value string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.records[fqdn] == nil {
+		d.records[fqdn] = make(map[string]bool)
+	}
+	d.records[fqdn][value] = true
+	return nil
+}
+
+func (d *fakeDNS) CleanUp(ctx context.Context, fqdn, value string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.records[fqdn], value)
+	return nil
+}
+
+func (d *fakeDNS) Nameservers(ctx context.Context, fqdn string) ([]string, error) {
+	return []string{"ns1.test.", "ns2.test."}, nil
+}
+
+func (d *fakeDNS) TXT(ctx context.Context, nameserver, fqdn string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var values []string
+	for value := range d.records[fqdn] {
+		d.asked[nameserver+value]++
+		if d.asked[nameserver+value] >= d.visibleAfter {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// propagated tells if all nameservers serve the value
+func (d *fakeDNS) propagated(fqdn, value string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.records[fqdn][value] && d.asked["ns1.test."+value] >= d.visibleAfter && d.asked["ns2.test."+value] >= d.visibleAfter
+}
+
+func TestWildcardDNS01(t *testing.T) {
+	dns := newFakeDNS(3)
+	ca := newFakeACME(t, "dns-01", func(typ, domain, token, keyAuth string) error {
+		sum := sha256.Sum256([]byte(keyAuth))
+		if !dns.propagated("_acme-challenge."+domain, base64.RawURLEncoding.EncodeToString(sum[:])) {
+			return errors.New("record is not served by all nameservers")
+		}
+		return nil
+	})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &wildcardManager{
+		client:              &acme.Client{Key: key, DirectoryURL: ca.directoryURL()},
+		host:                "jtf.test",
+		cache:               autocert.DirCache(t.TempDir()),
+		provider:            dns,
+		lookup:              dns,
+		propagationTimeout:  5 * time.Second,
+		propagationInterval: time.Millisecond,
+	}
+
+	if err := w.renewIfNeeded(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cert := w.certificate()
+	if cert == nil {
+		t.Fatal("no certificate after renewal")
+	}
+	for _, name := range []string{"jtf.test", "alice.jtf.test"} {
+		if err := cert.Leaf.VerifyHostname(name); err != nil {
+			t.Errorf("certificate does not cover %s: %v", name, err)
+		}
+	}
+
+	// the certificate is cached, the next start serves it without asking the directory
+	cached, err := w.load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Error("cached certificate differs from the obtained one")
+	}
+}
+
+func TestWaitForRecordTimeout(t *testing.T) {
+	dns := newFakeDNS(1 << 30)
+	w := &wildcardManager{
+		lookup:              dns,
+		propagationTimeout:  50 * time.Millisecond,
+		propagationInterval: time.Millisecond,
+	}
+	dns.Present(context.Background(), "_acme-challenge.jtf.test", "value")
+
+	if err := w.waitForRecord(context.Background(), "_acme-challenge.jtf.test", "value"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waitForRecord() = %v, want deadline exceeded", err)
+	}
+}
+
+// failingDNS never publishes records, the wildcard certificate is never obtained
+type failingDNS struct{}
+
+func (failingDNS) Present(ctx context.Context, fqdn, value string) error {
+	return errors.New("dns hosting is down")
+}
+
+func (failingDNS) CleanUp(ctx context.Context, fqdn, value string) error {
+	return nil
+}
+
+func TestNewServesFallbackMeanwhile(t *testing.T) {
+	ca := newFakeACME(t, "dns-01", func(typ, domain, token, keyAuth string) error {
+		return errors.New("no records")
+	})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback := &tls.Certificate{Certificate: [][]byte{ca.caCert.Raw}, PrivateKey: key}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m, err := New(ctx, &Options{
+		DirectoryURL: ca.directoryURL(),
+		CacheDir:     t.TempDir(),
+		Host:         "jtf.test",
+		DNSProvider:  failingDNS{},
+		Certificate:  fallback,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := m.TLSConfig().GetCertificate(&tls.ClientHelloInfo{ServerName: "alice.jtf.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert != fallback {
+		t.Error("fallback certificate is not served before the wildcard one is obtained")
+	}
+}
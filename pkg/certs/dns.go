@@ -0,0 +1,102 @@
+package certs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+)
+
+// DNSProvider publishes TXT records for dns-01 challenges, it is needed for the wildcard certificate
+type DNSProvider interface {
+	// Present creates TXT record fqdn (e.g. _acme-challenge.jtf.zohiddev.me) with value.
+	// The same fqdn can have several values at the same time.
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp removes the record created by Present
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// DNSProviderFactory creates provider, it reads its own settings from environment
+type DNSProviderFactory func() (DNSProvider, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]DNSProviderFactory)
+)
+
+// RegisterDNSProvider makes dns-01 provider available by name, providers register themselves in init
+func RegisterDNSProvider(name string, factory DNSProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if _, ok := providers[name]; ok {
+		panic("certs: dns provider registered twice: " + name)
+	}
+	providers[name] = factory
+}
+
+// NewDNSProvider creates registered provider by name
+func NewDNSProvider(name string) (DNSProvider, error) {
+	providersMu.RLock()
+	factory, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("certs: unknown dns provider %q, available: %v", name, DNSProviders())
+	}
+
+	return factory()
+}
+
+// DNSProviders returns names of registered providers
+func DNSProviders() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	RegisterDNSProvider("exec", newExecProvider)
+}
+
+// execProvider runs a script for every change, it works with any dns hosting which has a cli or api:
+//
+//	$ACME_DNS_EXEC_PATH present _acme-challenge.jtf.zohiddev.me <value>
+//	$ACME_DNS_EXEC_PATH cleanup _acme-challenge.jtf.zohiddev.me <value>
+type execProvider struct {
+	path string
+}
+
+func newExecProvider() (DNSProvider, error) {
+	path := os.Getenv("ACME_DNS_EXEC_PATH")
+	if path == "" {
+		return nil, fmt.Errorf("certs: ACME_DNS_EXEC_PATH is required for exec dns provider")
+	}
+
+	return &execProvider{path: path}, nil
+}
+
+func (p *execProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p *execProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+func (p *execProvider) run(ctx context.Context, action, fqdn, value string) error {
+	out, err := exec.CommandContext(ctx, p.path, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("certs: %s %s: %v: %s", action, fqdn, err, out)
+	}
+
+	return nil
+}
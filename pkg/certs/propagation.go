@@ -0,0 +1,99 @@
+package certs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// dns-01 challenge is accepted only after all authoritative nameservers serve the record
+	propagationTimeout  = 2 * time.Minute
+	propagationInterval = 5 * time.Second
+)
+
+// recordLookup finds the nameservers of the zone and asks one of them for TXT records, tests replace it
+type recordLookup interface {
+	Nameservers(ctx context.Context, fqdn string) ([]string, error)
+	TXT(ctx context.Context, nameserver, fqdn string) ([]string, error)
+}
+
+// authoritativeLookup asks the nameservers of the zone directly, so caches of resolvers do not hide the record
+type authoritativeLookup struct{}
+
+// Nameservers returns nameservers of the closest parent of the fqdn which has NS records
+func (authoritativeLookup) Nameservers(ctx context.Context, fqdn string) ([]string, error) {
+	for name := strings.TrimSuffix(fqdn, "."); strings.Contains(name, "."); name = name[strings.Index(name, ".")+1:] {
+		records, err := net.DefaultResolver.LookupNS(ctx, name+".")
+		if err != nil || len(records) == 0 {
+			continue
+		}
+
+		nameservers := make([]string, 0, len(records))
+		for _, ns := range records {
+			nameservers = append(nameservers, ns.Host)
+		}
+		return nameservers, nil
+	}
+
+	return nil, fmt.Errorf("certs: no nameservers found for %s", fqdn)
+}
+
+func (authoritativeLookup) TXT(ctx context.Context, nameserver, fqdn string) ([]string, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, net.JoinHostPort(strings.TrimSuffix(nameserver, "."), "53"))
+		},
+	}
+
+	return resolver.LookupTXT(ctx, strings.TrimSuffix(fqdn, ".")+".")
+}
+
+// waitForRecord polls authoritative nameservers until all of them serve the TXT record, ACME servers fail the
+// challenge for good when they do not see it
+func (w *wildcardManager) waitForRecord(ctx context.Context, fqdn, value string) error {
+	ctx, cancel := context.WithTimeout(ctx, w.propagationTimeout)
+	defer cancel()
+
+	nameservers, err := w.lookup.Nameservers(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if w.published(ctx, nameservers, fqdn, value) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("certs: %s is not served by all nameservers of the zone: %w", fqdn, ctx.Err())
+		case <-time.After(w.propagationInterval):
+		}
+	}
+}
+
+func (w *wildcardManager) published(ctx context.Context, nameservers []string, fqdn, value string) bool {
+	for _, ns := range nameservers {
+		values, err := w.lookup.TXT(ctx, ns, fqdn)
+		if err != nil || !contains(values, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,277 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// certificates are renewed when they expire in less than this
+	renewBefore   = 30 * 24 * time.Hour
+	renewInterval = 12 * time.Hour
+	// failed renewal is tried again sooner, the certificate may be missing at all
+	renewRetryInterval = 10 * time.Minute
+)
+
+// wildcardManager keeps certificate of host and *.host, obtained with dns-01 challenges
+type wildcardManager struct {
+	client   *acme.Client
+	email    string
+	host     string
+	cache    autocert.Cache
+	provider DNSProvider
+	lookup   recordLookup
+
+	propagationTimeout  time.Duration
+	propagationInterval time.Duration
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (w *wildcardManager) cacheKey() string {
+	return "wildcard+" + w.host
+}
+
+func (w *wildcardManager) certificate() *tls.Certificate {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.cert
+}
+
+// start loads the certificate from cache, then obtains and renews it in background. Obtaining takes minutes
+// with dns-01, the cached certificate or the fallback of the manager is served meanwhile.
+func (w *wildcardManager) start(ctx context.Context) {
+	cert, err := w.load(ctx)
+	switch {
+	case err == nil:
+		w.setCertificate(cert)
+	case !errors.Is(err, autocert.ErrCacheMiss):
+		log.Println("cached wildcard certificate is not loaded:", err)
+	}
+
+	go func() {
+		for {
+			wait := renewInterval
+			if err := w.renewIfNeeded(ctx); err != nil {
+				log.Println("wildcard certificate renewal failed:", err)
+				wait = renewRetryInterval
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+func (w *wildcardManager) renewIfNeeded(ctx context.Context) error {
+	cert := w.certificate()
+	if cert == nil {
+		// a broken cache entry is replaced with a new certificate
+		cert, _ = w.load(ctx)
+	}
+
+	if cert != nil && time.Until(cert.Leaf.NotAfter) > renewBefore {
+		w.setCertificate(cert)
+		return nil
+	}
+
+	cert, err := w.obtain(ctx)
+	if err != nil {
+		return err
+	}
+	w.setCertificate(cert)
+
+	return nil
+}
+
+func (w *wildcardManager) setCertificate(cert *tls.Certificate) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.cert = cert
+}
+
+func (w *wildcardManager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	if err := w.register(ctx); err != nil {
+		return nil, err
+	}
+
+	domains := []string{w.host, "*." + w.host}
+	order, err := w.client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, err
+	}
+
+	// challenges of host and *.host share the same record name, they are solved one by one
+	for _, url := range order.AuthzURLs {
+		if err := w.authorize(ctx, url); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = w.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: w.host},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	der, _, err := w.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.store(ctx, key, der); err != nil {
+		return nil, err
+	}
+
+	return certificateFromDER(key, der)
+}
+
+func (w *wildcardManager) register(ctx context.Context) error {
+	_, err := w.client.Register(ctx, &acme.Account{Contact: contact(w.email)}, acme.AcceptTOS)
+	if err == nil || errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil
+	}
+
+	return err
+}
+
+func (w *wildcardManager) authorize(ctx context.Context, url string) error {
+	authz, err := w.client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("certs: no dns-01 challenge for %s", authz.Identifier.Value)
+	}
+
+	value, err := w.client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+
+	fqdn := "_acme-challenge." + authz.Identifier.Value
+	if err := w.provider.Present(ctx, fqdn, value); err != nil {
+		return err
+	}
+	defer func() {
+		if err := w.provider.CleanUp(context.Background(), fqdn, value); err != nil {
+			log.Println("dns-01 cleanup failed:", err)
+		}
+	}()
+
+	if err := w.waitForRecord(ctx, fqdn, value); err != nil {
+		return err
+	}
+	if _, err := w.client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = w.client.WaitAuthorization(ctx, authz.URI)
+
+	return err
+}
+
+// store saves key and chain in one PEM like autocert does
+func (w *wildcardManager) store(ctx context.Context, key crypto.Signer, der [][]byte) error {
+	var buf bytes.Buffer
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(&buf, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}); err != nil {
+		return err
+	}
+	for _, b := range der {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: b}); err != nil {
+			return err
+		}
+	}
+
+	return w.cache.Put(ctx, w.cacheKey(), buf.Bytes())
+}
+
+func (w *wildcardManager) load(ctx context.Context) (*tls.Certificate, error) {
+	data, err := w.cache.Get(ctx, w.cacheKey())
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+func certificateFromDER(key crypto.Signer, der [][]byte) (*tls.Certificate, error) {
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: der,
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func contact(email string) []string {
+	if email == "" {
+		return nil
+	}
+
+	return []string{"mailto:" + email}
+}
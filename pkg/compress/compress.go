@@ -0,0 +1,190 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings which responses can be compressed with
+const (
+	Zstd   = "zstd"
+	Brotli = "br"
+	Gzip   = "gzip"
+)
+
+// preferred is the order codings are picked in when the client accepts them equally
+var preferred = []string{Zstd, Brotli, Gzip}
+
+var (
+	ErrNotGzip     = errors.New("not a gzip stream")
+	ErrMultistream = errors.New("gzip stream has more than one member")
+)
+
+// Negotiate picks content coding for the Accept-Encoding header, empty means the response is sent as it is
+func Negotiate(acceptEncoding string) string {
+	weights := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = v
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		weights[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range preferred {
+		q, ok := weights[coding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
+
+// Compressible tells if content of the type gets smaller when it is compressed, media and archives do not
+func Compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/xml", "application/javascript",
+		"application/x-javascript", "application/ecmascript", "application/yaml", "application/x-yaml",
+		"application/toml", "application/sql", "application/x-sh", "application/x-tar", "application/wasm":
+		return true
+	}
+
+	return false
+}
+
+// NewWriter returns writer which compresses into w with the content coding, it must be closed to flush the data
+func NewWriter(w io.Writer, coding string) (io.WriteCloser, error) {
+	switch coding {
+	case Zstd:
+		return zstd.NewWriter(w)
+	case Brotli:
+		return brotli.NewWriter(w), nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	}
+
+	return nil, errors.New("unknown content coding " + coding)
+}
+
+// Encode compresses data with the content coding
+func Encode(data []byte, coding string) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, coding)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Gunzip writes content of the single member gzip stream into w and returns its size. Streams which are
+// concatenated from several members are refused, GzipMember can not pass them through.
+func Gunzip(w io.Writer, data []byte) (int64, error) {
+	r := bytes.NewReader(data)
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, ErrNotGzip
+	}
+	zr.Multistream(false)
+
+	n, err := io.Copy(w, zr)
+	if err != nil {
+		return n, err
+	}
+	if r.Len() != 0 {
+		return n, ErrMultistream
+	}
+
+	return n, nil
+}
+
+// GzipMember returns raw deflate data of the single member gzip stream and crc32 of its content, so the content
+// can be put into zip without compressing it again. The stream should be checked with Gunzip first.
+func GzipMember(data []byte) ([]byte, uint32, error) {
+	const (
+		flagHCRC    = 1 << 1
+		flagExtra   = 1 << 2
+		flagName    = 1 << 3
+		flagComment = 1 << 4
+		headerSize  = 10
+		trailerSize = 8
+	)
+	if len(data) < headerSize+trailerSize || data[0] != 0x1f || data[1] != 0x8b || data[2] != 8 {
+		return nil, 0, ErrNotGzip
+	}
+
+	flags := data[3]
+	body := data[headerSize : len(data)-trailerSize]
+	if flags&flagExtra != 0 {
+		if len(body) < 2 {
+			return nil, 0, ErrNotGzip
+		}
+		n := int(binary.LittleEndian.Uint16(body))
+		if len(body) < 2+n {
+			return nil, 0, ErrNotGzip
+		}
+		body = body[2+n:]
+	}
+	for _, flag := range []byte{flagName, flagComment} {
+		if flags&flag == 0 {
+			continue
+		}
+		i := bytes.IndexByte(body, 0)
+		if i < 0 {
+			return nil, 0, ErrNotGzip
+		}
+		body = body[i+1:]
+	}
+	if flags&flagHCRC != 0 {
+		if len(body) < 2 {
+			return nil, 0, ErrNotGzip
+		}
+		body = body[2:]
+	}
+
+	return body, binary.LittleEndian.Uint32(data[len(data)-trailerSize:]), nil
+}
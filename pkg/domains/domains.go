@@ -0,0 +1,212 @@
+package domains
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// RecordPrefix is prepended to the custom domain for the TXT record, e.g. _jtf-challenge.files.acme.com
+	RecordPrefix = "_jtf-challenge."
+	// WellKnownPath is the file which has to contain the token when domain is verified over http
+	WellKnownPath = "/.well-known/jtf-challenge"
+
+	MethodDNS  = "dns"
+	MethodHTTP = "http"
+)
+
+var (
+	ErrInvalidDomain = errors.New("domain is invalid")
+	ErrNotVerified   = errors.New("verification token was not found in dns txt record or well-known file")
+	// ErrPrivateAddress is returned when the domain points into the network of the server
+	ErrPrivateAddress = errors.New("domain resolves to a private address")
+)
+
+// sharedAddressSpace is 100.64.0.0/10 used by carrier-grade NAT, IsPrivate does not cover it
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Resolver looks up TXT records, net.DefaultResolver satisfies it and tests can use a fake
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+type Verifier struct {
+	resolver Resolver
+	client   *http.Client
+}
+
+// NewVerifier creates verifier of domain ownership, nil resolver and client are replaced with defaults
+func NewVerifier(resolver Resolver, client *http.Client) *Verifier {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if client == nil {
+		client = newClient()
+	}
+
+	return &Verifier{
+		resolver: resolver,
+		client:   client,
+	}
+}
+
+// Verify checks that the token is published for domain, first in dns TXT record then in well-known file.
+// It returns the method which proved the ownership.
+func (v *Verifier) Verify(ctx context.Context, domain, token string) (string, error) {
+	if v.verifyDNS(ctx, domain, token) {
+		return MethodDNS, nil
+	}
+	if v.verifyHTTP(ctx, domain, token) {
+		return MethodHTTP, nil
+	}
+
+	return "", ErrNotVerified
+}
+
+func (v *Verifier) verifyDNS(ctx context.Context, domain, token string) bool {
+	records, err := v.resolver.LookupTXT(ctx, RecordPrefix+domain)
+	if err != nil {
+		return false
+	}
+
+	for _, record := range records {
+		if strings.TrimSpace(record) == token {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *Verifier) verifyHTTP(ctx context.Context, domain, token string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+domain+WellKnownPath, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(body)) == token
+}
+
+// newClient fetches well-known files of public hosts only. Domains are entered by users, so the server must not be
+// made to request its own network: addresses are checked after they are resolved and redirects stay on the host.
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// no proxy, the address which is checked is the address which is connected to
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 5 * time.Second,
+			MaxIdleConns:          1,
+			DisableKeepAlives:     true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Hostname() != via[0].URL.Hostname() {
+				return errors.New("redirect to another host")
+			}
+			return nil
+		},
+	}
+}
+
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// Normalize lowercases the domain and checks that it is a valid hostname with at least two labels
+func Normalize(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "https://")
+	domain = strings.TrimPrefix(domain, "http://")
+	domain = strings.TrimSuffix(domain, "/")
+	domain = strings.TrimSuffix(domain, ".")
+
+	if len(domain) == 0 || len(domain) > 253 {
+		return "", ErrInvalidDomain
+	}
+
+	// ip addresses are not domains, the top level label has a letter so 127.1 is refused too
+	if net.ParseIP(domain) != nil {
+		return "", ErrInvalidDomain
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 || strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "", ErrInvalidDomain
+	}
+	for _, label := range labels {
+		if !ValidLabel(label) {
+			return "", ErrInvalidDomain
+		}
+	}
+
+	return domain, nil
+}
+
+// NewToken generates the value users publish to prove the ownership
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("jtf-verification=%s", hex.EncodeToString(b)), nil
+}
+
+// ValidLabel checks one DNS label: 1-63 characters of a-z, 0-9 and hyphen, not starting or ending with hyphen
+func ValidLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 {
+		return false
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,126 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeResolver answers TXT lookups from the map, missing names fail like NXDOMAIN
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	return records, nil
+}
+
+func TestVerify(t *testing.T) {
+	const (
+		domain = "files.example.com"
+		token  = "jtf-verification=0123456789abcdef"
+	)
+
+	tests := []struct {
+		name       string
+		txt        []string // nil when there is no record
+		wellKnown  string   // empty when the file is missing
+		wantMethod string
+		wantErr    error
+	}{
+		{name: "txt record", txt: []string{"v=spf1 -all", token}, wantMethod: MethodDNS},
+		{name: "txt record with spaces", txt: []string{" " + token + " "}, wantMethod: MethodDNS},
+		{name: "txt record wins over file", txt: []string{token}, wellKnown: token, wantMethod: MethodDNS},
+		{name: "well-known file", wellKnown: token + "\n", wantMethod: MethodHTTP},
+		{name: "other txt record and file", txt: []string{"jtf-verification=other"}, wellKnown: token, wantMethod: MethodHTTP},
+		{name: "wrong token everywhere", txt: []string{"jtf-verification=other"}, wellKnown: "jtf-verification=other", wantErr: ErrNotVerified},
+		{name: "nothing published", wantErr: ErrNotVerified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Host != domain || r.URL.Path != WellKnownPath || tt.wellKnown == "" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(tt.wellKnown))
+			}))
+			defer srv.Close()
+
+			// every host is served by the test server
+			client := srv.Client()
+			client.Transport = &http.Transport{
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, network, srv.Listener.Addr().String())
+				},
+			}
+			resolver := fakeResolver{}
+			if tt.txt != nil {
+				resolver[RecordPrefix+domain] = tt.txt
+			}
+
+			method, err := NewVerifier(resolver, client).Verify(context.Background(), domain, token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if method != tt.wantMethod {
+				t.Errorf("Verify() method = %q, want %q", method, tt.wantMethod)
+			}
+		})
+	}
+}
+
+func TestVerifyRefusesPrivateAddress(t *testing.T) {
+	const token = "jtf-verification=0123456789abcdef"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(token))
+	}))
+	defer srv.Close()
+
+	// the default client does not connect to the network of the server
+	domain := strings.TrimPrefix(srv.URL, "http://")
+	if _, err := NewVerifier(fakeResolver{}, nil).Verify(context.Background(), domain, token); !errors.Is(err, ErrNotVerified) {
+		t.Fatalf("Verify(%s) error = %v, want ErrNotVerified", domain, err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"Files.Example.COM", "files.example.com"},
+		{"https://files.example.com/", "files.example.com"},
+		{"files.example.com.", "files.example.com"},
+		{"localhost", ""},
+		{"127.0.0.1", ""},
+		{"127.1", ""},
+		{"::1", ""},
+		{"-files.example.com", ""},
+		{"files_1.example.com", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := Normalize(tt.domain)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Normalize(%q) = %q, want error", tt.domain, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q", tt.domain, got, err, tt.want)
+		}
+	}
+}
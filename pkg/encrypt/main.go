@@ -1,53 +1,94 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"log"
+	"os"
 
-	"github.com/SaidovZohid/swiftsend.it/api/handlers"
+	"github.com/SaidovZohid/swiftsend.it/config"
+	"github.com/SaidovZohid/swiftsend.it/pkg/envelope"
 )
 
-const (
-	setKeyMsg     = "set your secret key"
-	privateKeyMsg = "private key here"
-)
+const usage = `usage: go run ./pkg/encrypt/main.go <command> < input
+
+The secret key is ENCRYPT_SECRET_KEY, it is read from .env like the server does.
+
+commands:
+  encrypt    encrypts the private key read from stdin and prints it for ENCRYPTED_PRIVATE_KEY
+  decrypt    prints the private key, input is ENCRYPTED_PRIVATE_KEY when stdin is empty
+  reencrypt  encrypts ENCRYPTED_PRIVATE_KEY again in the current format, with NEW_ENCRYPT_SECRET_KEY
+             when it is set so the secret key can be changed
+
+examples:
+  go run ./pkg/encrypt/main.go encrypt < ~/.ssh/jtf_host_key
+  NEW_ENCRYPT_SECRET_KEY=<new key> go run ./pkg/encrypt/main.go reencrypt < /dev/null`
 
 func main() {
-	secretKey := []byte(setKeyMsg)
-	if string(secretKey) == setKeyMsg {
-		log.Fatal("generate secret key by running this file 'go run ./pkg/random_key/main.go'")
+	if len(os.Args) != 2 {
+		log.Fatal(usage)
 	}
 
-	// paste here your private key in order to encrypt it and after encyption copy/paste to .env file ENCRYPTED_PRIVATE_KEY key.
-	privateKey := []byte(privateKeyMsg)
-	if string(privateKey) == privateKeyMsg {
-		log.Fatal("set your private key!")
+	cfg := config.Load()
+	if cfg.EncryptSecretKey == "" {
+		log.Fatal("ENCRYPT_SECRET_KEY is not set, generate it by running 'go run ./pkg/random_key/main.go'")
 	}
+	secret := []byte(cfg.EncryptSecretKey)
 
-	// Encrypt the data
-	encrypted, err := handlers.Encrypt(privateKey, secretKey)
+	input, err := io.ReadAll(os.Stdin)
 	if err != nil {
-		fmt.Println("Encryption error:", err)
-		return
+		log.Fatal("reading stdin: ", err)
 	}
 
-	// Convert the encrypted data to a base64-encoded string
-	encoded := base64.StdEncoding.EncodeToString(encrypted)
-	fmt.Println("Encrypted data:", encoded)
+	switch os.Args[1] {
+	case "encrypt":
+		if len(bytes.TrimSpace(input)) == 0 {
+			log.Fatal("pipe the private key into stdin")
+		}
+		printSealed(input, secret)
+	case "decrypt":
+		fmt.Print(string(open(input, cfg.EncryptedPrivateKey, secret)))
+	case "reencrypt":
+		plaintext := open(input, cfg.EncryptedPrivateKey, secret)
+		if newSecret := os.Getenv("NEW_ENCRYPT_SECRET_KEY"); newSecret != "" {
+			secret = []byte(newSecret)
+		}
+		printSealed(plaintext, secret)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// open decrypts base64 envelope from the input, or from the fallback when the input is empty
+func open(input []byte, fallback string, secret []byte) []byte {
+	encoded := string(bytes.TrimSpace(input))
+	if encoded == "" {
+		encoded = fallback
+	}
+	if encoded == "" {
+		log.Fatal("pipe the encrypted private key into stdin or set ENCRYPTED_PRIVATE_KEY")
+	}
 
-	// Decrypt the data
 	decoded, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
-		fmt.Println("Decoding error:", err)
-		return
+		log.Fatal("decoding error: ", err)
+	}
+	plaintext, err := envelope.Open(decoded, secret)
+	if err != nil {
+		log.Fatal("decryption error: ", err)
 	}
 
-	decrypted, err := handlers.Decrypt(decoded, secretKey)
+	return plaintext
+}
+
+func printSealed(plaintext, secret []byte) {
+	sealed, err := envelope.Seal(plaintext, secret)
 	if err != nil {
-		fmt.Println("Decryption error:", err)
-		return
+		log.Fatal("encryption error: ", err)
 	}
 
-	fmt.Println("Decrypted data:", string(decrypted))
+	// copy/paste it to .env file ENCRYPTED_PRIVATE_KEY key
+	fmt.Println(base64.StdEncoding.EncodeToString(sealed))
 }
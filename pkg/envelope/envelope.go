@@ -0,0 +1,166 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Envelope layout:
+//
+//	"JTFE" | version | kdf | log2 N | r | p | salt length | salt | nonce | AES-256-GCM ciphertext
+//
+// The key is derived from the secret with scrypt, everything before the ciphertext is authenticated with it.
+const (
+	magic   = "JTFE"
+	version = 1
+
+	kdfScrypt = 1
+
+	keySize  = 32
+	saltSize = 16
+	maxLogN  = 20 // 1GB of memory with r=8
+)
+
+// DefaultParams of scrypt, about 100ms and 32MB per derivation
+var DefaultParams = Params{LogN: 15, R: 8, P: 1}
+
+var (
+	ErrFormat   = errors.New("envelope: not an encrypted envelope")
+	ErrWrongKey = errors.New("envelope: wrong secret key or the data was changed")
+	ErrVersion  = errors.New("envelope: unsupported version, upgrade the server")
+)
+
+// Params of scrypt, the cost is 2^LogN
+type Params struct {
+	LogN uint8
+	R    uint8
+	P    uint8
+}
+
+func (p Params) valid() bool {
+	return p.LogN >= 1 && p.LogN <= maxLogN && p.R > 0 && p.P > 0
+}
+
+// Seal encrypts plaintext with key derived from the secret with default params
+func Seal(plaintext, secret []byte) ([]byte, error) {
+	return SealWithParams(plaintext, secret, DefaultParams)
+}
+
+func SealWithParams(plaintext, secret []byte, params Params) ([]byte, error) {
+	if !params.valid() {
+		return nil, fmt.Errorf("envelope: invalid scrypt params %+v", params)
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	header := append([]byte(magic), version, kdfScrypt, params.LogN, params.R, params.P, byte(len(salt)))
+	header = append(header, salt...)
+
+	aead, err := newAEAD(secret, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// Open decrypts the envelope, data of the old unauthenticated format is read too
+func Open(data, secret []byte) ([]byte, error) {
+	if IsLegacy(data) {
+		return openLegacy(data, secret)
+	}
+
+	if len(data) < len(magic)+6 {
+		return nil, ErrFormat
+	}
+	if data[len(magic)] != version {
+		return nil, ErrVersion
+	}
+	fixed := data[len(magic)+1 : len(magic)+6]
+	if fixed[0] != kdfScrypt {
+		return nil, ErrVersion
+	}
+	params := Params{LogN: fixed[1], R: fixed[2], P: fixed[3]}
+	if !params.valid() {
+		return nil, ErrFormat
+	}
+
+	headerSize := len(magic) + 6 + int(fixed[4])
+	if len(data) < headerSize {
+		return nil, ErrFormat
+	}
+	header, salt := data[:headerSize], data[len(magic)+6:headerSize]
+
+	aead, err := newAEAD(secret, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	rest := data[headerSize:]
+	if len(rest) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrFormat
+	}
+
+	plaintext, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return plaintext, nil
+}
+
+// IsLegacy tells if the data is in the old AES-CBC format which has no header, it should be sealed again
+func IsLegacy(data []byte) bool {
+	return !bytes.HasPrefix(data, []byte(magic))
+}
+
+func newAEAD(secret, salt []byte, params Params) (cipher.AEAD, error) {
+	key, err := scrypt.Key(secret, salt, 1<<params.LogN, int(params.R), int(params.P), keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// openLegacy reads the old format, iv and AES-CBC ciphertext with PKCS#7 padding and the secret used as the key.
+// There is no MAC, so a wrong key is noticed only when the padding is broken, which is most of the times.
+func openLegacy(data, secret []byte) ([]byte, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: secret key of the old format must be 16, 24 or 32 bytes: %w", ErrWrongKey)
+	}
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, ErrFormat
+	}
+
+	plaintext := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plaintext, data[aes.BlockSize:])
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, ErrWrongKey
+	}
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		if int(b) != padding {
+			return nil, ErrWrongKey
+		}
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
@@ -0,0 +1,373 @@
+package hostkeys
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/envelope"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Types of host keys the server has, in the order they are offered
+var Types = []string{"ed25519", "ecdsa", "rsa"}
+
+const (
+	// next keys are kept next to the current ones until the rotation window is over
+	nextSuffix = ".next"
+	// rotationFile keeps the time the next keys replace the current ones
+	rotationFile = "rotation"
+
+	rsaBits = 3072
+)
+
+var ErrRotating = errors.New("hostkeys: rotation is in progress already")
+
+// Store keeps host keys sealed with the secret in a directory, one file per key type like sshd does
+type Store struct {
+	dir    string
+	secret []byte
+}
+
+func NewStore(dir string, secret []byte) *Store {
+	return &Store{
+		dir:    dir,
+		secret: secret,
+	}
+}
+
+// Import saves the private key in PEM as the key of its type when there is none yet, so clients which trust it
+// keep trusting the server. It returns false when the key of the type exists already.
+func (s *Store) Import(pemBytes []byte) (bool, error) {
+	signer, err := gossh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return false, err
+	}
+	typ, err := keyType(signer.PublicKey())
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := os.Stat(s.path(typ)); err == nil || !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if err := s.save(s.path(typ), pemBytes); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Load reads the host keys, keys of the types which are missing are generated and saved
+func (s *Store) Load() (*Keys, error) {
+	current := make(map[string]gossh.Signer, len(Types))
+	for _, typ := range Types {
+		signer, err := s.read(s.path(typ))
+		if errors.Is(err, os.ErrNotExist) {
+			signer, err = s.generate(s.path(typ), typ)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("hostkeys: %s key: %w", typ, err)
+		}
+		current[typ] = signer
+	}
+
+	keys := &Keys{store: s, current: current}
+	if _, err := keys.Refresh(time.Now()); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Rotate generates the next keys, they are advertised to clients during the window and replace the current
+// keys when it is over
+func (s *Store) Rotate(window time.Duration, now time.Time) (time.Time, error) {
+	if rotateAt, err := s.rotateAt(); err != nil || !rotateAt.IsZero() {
+		if err == nil {
+			err = fmt.Errorf("%w, it ends at %s", ErrRotating, rotateAt.Format(time.RFC3339))
+		}
+		return time.Time{}, err
+	}
+
+	for _, typ := range Types {
+		if _, err := s.generate(s.path(typ)+nextSuffix, typ); err != nil {
+			return time.Time{}, fmt.Errorf("hostkeys: %s key: %w", typ, err)
+		}
+	}
+	rotateAt := now.Add(window).UTC().Truncate(time.Second)
+	// the time is written last, next keys are not picked up before all of them exist
+	if err := s.write(rotationFile, []byte(rotateAt.Format(time.RFC3339)+"\n")); err != nil {
+		return time.Time{}, err
+	}
+
+	return rotateAt, nil
+}
+
+// rotateAt returns the time the next keys replace the current ones, zero when keys are not rotated
+func (s *Store) rotateAt() (time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, rotationFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	rotateAt, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("hostkeys: reading %s: %w", rotationFile, err)
+	}
+	return rotateAt, nil
+}
+
+// promote moves the next keys over the current ones and ends the rotation
+func (s *Store) promote() error {
+	for _, typ := range Types {
+		err := os.Rename(s.path(typ)+nextSuffix, s.path(typ))
+		// the key was moved already when promoting was interrupted
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	err := os.Remove(filepath.Join(s.dir, rotationFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *Store) path(typ string) string {
+	return filepath.Join(s.dir, "ssh_host_"+typ+"_key")
+}
+
+func (s *Store) read(path string) (gossh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pemBytes, err := envelope.Open(data, s.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return gossh.ParsePrivateKey(pemBytes)
+}
+
+func (s *Store) generate(path, typ string) (gossh.Signer, error) {
+	var (
+		key interface{}
+		err error
+	)
+	switch typ {
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "ecdsa":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, rsaBits)
+	default:
+		err = fmt.Errorf("unknown key type %s", typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.save(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, err
+	}
+
+	return gossh.NewSignerFromKey(key)
+}
+
+func (s *Store) save(path string, pemBytes []byte) error {
+	sealed, err := envelope.Seal(pemBytes, s.secret)
+	if err != nil {
+		return err
+	}
+
+	return s.write(filepath.Base(path), sealed)
+}
+
+// write replaces the file with a temporary one, so a key is never half written
+func (s *Store) write(name string, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+// Fingerprint of the host key like "ssh-keygen -l" prints it
+type Fingerprint struct {
+	Type   string // ED25519, ECDSA or RSA
+	SHA256 string
+	Next   bool // the key replaces the current key of its type after the rotation
+}
+
+// Keys are host keys the server signs with and, during rotation, the next keys which replace them
+type Keys struct {
+	store *Store
+
+	mu       sync.RWMutex
+	current  map[string]gossh.Signer
+	next     map[string]gossh.Signer
+	rotateAt time.Time
+}
+
+// Signers returns the current keys
+func (k *Keys) Signers() []gossh.Signer {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return ordered(k.current)
+}
+
+// Advertised returns the current and the next keys, they are sent to clients so they learn the next keys
+// before they are used
+func (k *Keys) Advertised() []gossh.Signer {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return append(ordered(k.current), ordered(k.next)...)
+}
+
+// RotateAt returns the time the next keys replace the current ones, zero when keys are not rotated
+func (k *Keys) RotateAt() time.Time {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.rotateAt
+}
+
+func (k *Keys) Fingerprints() []Fingerprint {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var fingerprints []Fingerprint
+	for i, keys := range []map[string]gossh.Signer{k.current, k.next} {
+		for _, typ := range Types {
+			if signer, ok := keys[typ]; ok {
+				fingerprints = append(fingerprints, Fingerprint{
+					Type:   strings.ToUpper(typ),
+					SHA256: gossh.FingerprintSHA256(signer.PublicKey()),
+					Next:   i == 1,
+				})
+			}
+		}
+	}
+
+	return fingerprints
+}
+
+// Refresh picks up the rotation started by Store.Rotate and replaces the current keys when its window is over.
+// It returns true when the current keys were replaced.
+func (k *Keys) Refresh(now time.Time) (bool, error) {
+	rotateAt, err := k.store.rotateAt()
+	if err != nil {
+		return false, err
+	}
+
+	k.mu.RLock()
+	changed := !rotateAt.Equal(k.rotateAt)
+	k.mu.RUnlock()
+
+	if changed {
+		next := map[string]gossh.Signer{}
+		if !rotateAt.IsZero() {
+			for _, typ := range Types {
+				signer, err := k.store.read(k.store.path(typ) + nextSuffix)
+				if err != nil {
+					return false, fmt.Errorf("hostkeys: next %s key: %w", typ, err)
+				}
+				next[typ] = signer
+			}
+		}
+
+		k.mu.Lock()
+		k.next, k.rotateAt = next, rotateAt
+		k.mu.Unlock()
+	}
+	if rotateAt.IsZero() || now.Before(rotateAt) {
+		return false, nil
+	}
+
+	if err := k.store.promote(); err != nil {
+		return false, err
+	}
+	k.mu.Lock()
+	for typ, signer := range k.next {
+		k.current[typ] = signer
+	}
+	k.next, k.rotateAt = nil, time.Time{}
+	k.mu.Unlock()
+
+	return true, nil
+}
+
+// Lookup returns the advertised key with the marshaled public key, nil when there is none
+func (k *Keys) Lookup(publicKey []byte) gossh.Signer {
+	for _, signer := range k.Advertised() {
+		if bytes.Equal(signer.PublicKey().Marshal(), publicKey) {
+			return signer
+		}
+	}
+
+	return nil
+}
+
+func ordered(keys map[string]gossh.Signer) []gossh.Signer {
+	var signers []gossh.Signer
+	for _, typ := range Types {
+		if signer, ok := keys[typ]; ok {
+			signers = append(signers, signer)
+		}
+	}
+
+	return signers
+}
+
+func keyType(key gossh.PublicKey) (string, error) {
+	switch t := key.Type(); {
+	case t == gossh.KeyAlgoED25519:
+		return "ed25519", nil
+	case strings.HasPrefix(t, "ecdsa-sha2-"):
+		return "ecdsa", nil
+	case t == gossh.KeyAlgoRSA:
+		return "rsa", nil
+	default:
+		return "", fmt.Errorf("hostkeys: %s host keys are not supported", t)
+	}
+}
@@ -0,0 +1,137 @@
+package links
+
+import (
+	"net"
+	"strings"
+)
+
+// Routing modes of generated links
+const (
+	// ModePath puts everything under the base host: https://jtf.example.com/download/<subdomain>/<link>
+	ModePath = "path"
+	// ModeSubdomain uses hostnames: https://<subdomain>.jtf.example.com/<link>, https://direct.jtf.example.com/<link>
+	ModeSubdomain = "subdomain"
+
+	// Unknown is the subdomain of links sent by users without verified subdomain
+	Unknown = "unknown"
+	// Direct and Delete are the hostnames of direct download and delete links in subdomain mode
+	Direct = "direct"
+	Delete = "delete"
+)
+
+type Options struct {
+	Scheme string // http or https, https by default
+	Host   string // apex host without port, e.g. jtf.zohiddev.me
+	Port   string // optional port, empty for default port of the scheme
+	Mode   string // ModePath or ModeSubdomain, ModePath by default
+}
+
+// Builder builds every link the service gives to users, so they are the same in ssh output, pages and cookies
+type Builder struct {
+	scheme string
+	host   string
+	port   string
+	mode   string
+}
+
+func New(opt *Options) *Builder {
+	b := &Builder{
+		scheme: strings.ToLower(opt.Scheme),
+		host:   strings.ToLower(strings.TrimSuffix(opt.Host, ".")),
+		port:   strings.TrimPrefix(opt.Port, ":"),
+		mode:   opt.Mode,
+	}
+	if b.scheme == "" {
+		b.scheme = "https"
+	}
+	if b.mode != ModeSubdomain {
+		b.mode = ModePath
+	}
+	// default ports are not written in links
+	if (b.scheme == "https" && b.port == "443") || (b.scheme == "http" && b.port == "80") {
+		b.port = ""
+	}
+
+	return b
+}
+
+func (b *Builder) Mode() string {
+	return b.mode
+}
+
+// Host returns apex host without port
+func (b *Builder) Host() string {
+	return b.host
+}
+
+// Site returns base url of the website, e.g. https://jtf.zohiddev.me
+func (b *Builder) Site() string {
+	return b.scheme + "://" + b.hostPort(b.host)
+}
+
+// Subdomain returns the page of verified user subdomain
+func (b *Builder) Subdomain(subdomain string) string {
+	if b.mode == ModeSubdomain {
+		return b.scheme + "://" + b.hostPort(subdomain+"."+b.host)
+	}
+
+	return b.Site() + "/domain/" + subdomain
+}
+
+// Domain returns the page of verified user custom domain, e.g. https://files.acme.com
+func (b *Builder) Domain(domain string) string {
+	return b.scheme + "://" + b.hostPort(domain)
+}
+
+// SubdomainHost returns the subdomain page without scheme to show it to users, e.g. zohid.jtf.zohiddev.me
+func (b *Builder) SubdomainHost(subdomain string) string {
+	return strings.TrimPrefix(b.Subdomain(subdomain), b.scheme+"://")
+}
+
+// Download returns the download page of the link, empty subdomain is for not verified users
+func (b *Builder) Download(subdomain, link string) string {
+	if subdomain == "" {
+		subdomain = Unknown
+	}
+	if b.mode == ModeSubdomain {
+		return b.Subdomain(subdomain) + "/" + link
+	}
+
+	return b.Site() + "/download/" + subdomain + "/" + link
+}
+
+// Direct returns the link which downloads the file without the page
+func (b *Builder) Direct(link string) string {
+	if b.mode == ModeSubdomain {
+		return b.scheme + "://" + b.hostPort(Direct+"."+b.host) + "/" + link
+	}
+
+	return b.Site() + "/direct/" + link
+}
+
+// Delete returns the link which deletes the sent file
+func (b *Builder) Delete(link string) string {
+	if b.mode == ModeSubdomain {
+		return b.scheme + "://" + b.hostPort(Delete+"."+b.host) + "/" + link
+	}
+
+	return b.Site() + "/delete/" + link
+}
+
+// CookieDomain returns the domain of auth cookie. In subdomain mode it is shared with all subdomains,
+// in path mode cookie is left host only
+func (b *Builder) CookieDomain() string {
+	if b.mode == ModeSubdomain {
+		return "." + b.host
+	}
+
+	return ""
+}
+
+func (b *Builder) hostPort(host string) string {
+	if b.port == "" {
+		return host
+	}
+
+	return net.JoinHostPort(host, b.port)
+}
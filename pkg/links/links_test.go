@@ -0,0 +1,96 @@
+package links
+
+import "testing"
+
+func TestBuilder(t *testing.T) {
+	tests := []struct {
+		name      string
+		opt       Options
+		site      string
+		download  string
+		unknown   string
+		direct    string
+		delete    string
+		cookie    string
+		subdomain string
+	}{
+		{
+			name:      "path mode",
+			opt:       Options{Scheme: "https", Host: "jtf.example.com"},
+			site:      "https://jtf.example.com",
+			download:  "https://jtf.example.com/download/zohid/abc123",
+			unknown:   "https://jtf.example.com/download/unknown/abc123",
+			direct:    "https://jtf.example.com/direct/abc123",
+			delete:    "https://jtf.example.com/delete/abc123",
+			cookie:    "",
+			subdomain: "jtf.example.com/domain/zohid",
+		},
+		{
+			name:      "path mode with port",
+			opt:       Options{Scheme: "http", Host: "localhost", Port: ":3000", Mode: ModePath},
+			site:      "http://localhost:3000",
+			download:  "http://localhost:3000/download/zohid/abc123",
+			unknown:   "http://localhost:3000/download/unknown/abc123",
+			direct:    "http://localhost:3000/direct/abc123",
+			delete:    "http://localhost:3000/delete/abc123",
+			cookie:    "",
+			subdomain: "localhost:3000/domain/zohid",
+		},
+		{
+			name:      "subdomain mode",
+			opt:       Options{Host: "JTF.example.com.", Mode: ModeSubdomain},
+			site:      "https://jtf.example.com",
+			download:  "https://zohid.jtf.example.com/abc123",
+			unknown:   "https://unknown.jtf.example.com/abc123",
+			direct:    "https://direct.jtf.example.com/abc123",
+			delete:    "https://delete.jtf.example.com/abc123",
+			cookie:    ".jtf.example.com",
+			subdomain: "zohid.jtf.example.com",
+		},
+		{
+			name:      "subdomain mode with port",
+			opt:       Options{Scheme: "http", Host: "jtf.localhost", Port: "8080", Mode: ModeSubdomain},
+			site:      "http://jtf.localhost:8080",
+			download:  "http://zohid.jtf.localhost:8080/abc123",
+			unknown:   "http://unknown.jtf.localhost:8080/abc123",
+			direct:    "http://direct.jtf.localhost:8080/abc123",
+			delete:    "http://delete.jtf.localhost:8080/abc123",
+			cookie:    ".jtf.localhost",
+			subdomain: "zohid.jtf.localhost:8080",
+		},
+		{
+			name:      "default port is left out",
+			opt:       Options{Scheme: "https", Host: "jtf.example.com", Port: "443", Mode: ModeSubdomain},
+			site:      "https://jtf.example.com",
+			download:  "https://zohid.jtf.example.com/abc123",
+			unknown:   "https://unknown.jtf.example.com/abc123",
+			direct:    "https://direct.jtf.example.com/abc123",
+			delete:    "https://delete.jtf.example.com/abc123",
+			cookie:    ".jtf.example.com",
+			subdomain: "zohid.jtf.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(&tt.opt)
+
+			checks := []struct {
+				what, got, want string
+			}{
+				{"Site", b.Site(), tt.site},
+				{"Download", b.Download("zohid", "abc123"), tt.download},
+				{"Download of unknown", b.Download("", "abc123"), tt.unknown},
+				{"Direct", b.Direct("abc123"), tt.direct},
+				{"Delete", b.Delete("abc123"), tt.delete},
+				{"CookieDomain", b.CookieDomain(), tt.cookie},
+				{"SubdomainHost", b.SubdomainHost("zohid"), tt.subdomain},
+			}
+			for _, c := range checks {
+				if c.got != c.want {
+					t.Errorf("%s = %q, want %q", c.what, c.got, c.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"expvar"
+)
+
+// Reasons of rejected uploads
+const (
+	RejectedTooLarge       = "too_large"
+	RejectedDailyTransfers = "daily_transfers"
+	RejectedMonthlyBytes   = "monthly_bytes"
+)
+
+// Results of scans
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanFailed   = "failed"
+)
+
+// Counters are published by expvar at /debug/vars
+var (
+	Uploads         = expvar.NewInt("uploads")
+	UploadedBytes   = expvar.NewInt("uploaded_bytes")
+	RejectedUploads = expvar.NewMap("rejected_uploads") // by reason
+	Scans           = expvar.NewMap("scans")            // by result
+)
+
+// Uploaded counts the finished upload
+func Uploaded(size int64) {
+	Uploads.Add(1)
+	UploadedBytes.Add(size)
+}
+
+// Rejected counts the upload rejected for the reason
+func Rejected(reason string) {
+	RejectedUploads.Add(reason, 1)
+}
+
+// Scanned counts the scan of the uploaded file
+func Scanned(result string) {
+	Scans.Add(result, 1)
+}
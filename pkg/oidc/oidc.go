@@ -0,0 +1,325 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrUnknownProvider = errors.New("login provider is not configured")
+	ErrInvalidIDToken  = errors.New("id token is invalid")
+)
+
+// Config of a login provider. Providers with Issuer are OpenID Connect providers and their endpoints come from
+// the discovery document, plain OAuth2 providers like GitHub set AuthURL, TokenURL and UserInfoURL instead.
+type Config struct {
+	Name         string // used in urls, /login/<name>
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	// EmailsURL lists emails of the user with their verification, GitHub does not tell it in userinfo
+	EmailsURL string
+}
+
+// Claims is the user which signed in
+type Claims struct {
+	Subject       string // stable id of the user at the provider
+	Username      string
+	Name          string
+	Email         string
+	EmailVerified bool
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keySet
+}
+
+// NewProvider creates login provider, nil client is replaced with default one.
+// Discovery document is fetched on first login, so an identity provider being down does not stop the server.
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Provider{
+		cfg:    cfg,
+		client: client,
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Provider) DisplayName() string {
+	if p.cfg.DisplayName != "" {
+		return p.cfg.DisplayName
+	}
+
+	return p.cfg.Name
+}
+
+// IsOIDC tells if the provider issues id tokens
+func (p *Provider) IsOIDC() bool {
+	return p.cfg.Issuer != ""
+}
+
+// AuthCodeURL is where the user is sent to sign in, verifier is the PKCE code verifier
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	conf, err := p.oauth2Config(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	opts := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", CodeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+	if p.IsOIDC() {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+
+	return conf.AuthCodeURL(state, opts...), nil
+}
+
+// Exchange redeems the code and returns the user, id token of OpenID Connect providers must carry the nonce
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	conf, err := p.oauth2Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := conf.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.IsOIDC() {
+		return p.userInfo(ctx, token.AccessToken)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrInvalidIDToken
+	}
+	claims, err := p.verifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// some providers put email only in userinfo
+	if claims.Email == "" && p.discovery.UserinfoEndpoint != "" {
+		info, err := p.userInfo(ctx, token.AccessToken)
+		if err == nil && info.Subject == claims.Subject {
+			claims.Email, claims.EmailVerified = info.Email, info.EmailVerified
+		}
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) oauth2Config(ctx context.Context) (*oauth2.Config, error) {
+	endpoint := oauth2.Endpoint{
+		AuthURL:   p.cfg.AuthURL,
+		TokenURL:  p.cfg.TokenURL,
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+	if p.IsOIDC() {
+		d, err := p.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.AuthURL, endpoint.TokenURL = d.AuthorizationEndpoint, d.TokenEndpoint
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.cfg.Scopes,
+		Endpoint:     endpoint,
+	}, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d discovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", "", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("issuer %q of discovery document does not match %q", d.Issuer, p.cfg.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksURI == "" {
+		return nil, errors.New("discovery document misses endpoints")
+	}
+
+	p.discovery = &d
+	p.keys = newKeySet(d.JwksURI, p.client)
+
+	return p.discovery, nil
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return p.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if !claims.VerifyIssuer(p.discovery.Issuer, true) {
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidIDToken)
+	}
+	if !claims.VerifyAudience(p.cfg.ClientID, true) {
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalidIDToken)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("%w: wrong nonce", ErrInvalidIDToken)
+	}
+
+	return claimsFromMap(claims), nil
+}
+
+// userInfo reads the user from userinfo endpoint, GitHub style fields are understood too
+func (p *Provider) userInfo(ctx context.Context, accessToken string) (*Claims, error) {
+	endpoint := p.cfg.UserInfoURL
+	if p.IsOIDC() {
+		endpoint = p.discovery.UserinfoEndpoint
+	}
+
+	info := map[string]interface{}{}
+	if err := p.getJSON(ctx, endpoint, accessToken, &info); err != nil {
+		return nil, err
+	}
+
+	claims := claimsFromMap(info)
+	if claims.Subject == "" {
+		return nil, errors.New("userinfo has no subject")
+	}
+
+	if p.cfg.EmailsURL != "" {
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := p.getJSON(ctx, p.cfg.EmailsURL, accessToken, &emails); err != nil {
+			return nil, err
+		}
+		for _, e := range emails {
+			if e.Primary && e.Verified {
+				claims.Email, claims.EmailVerified = e.Email, true
+			}
+		}
+	}
+
+	return claims, nil
+}
+
+func claimsFromMap(m map[string]interface{}) *Claims {
+	str := func(keys ...string) string {
+		for _, key := range keys {
+			switch v := m[key].(type) {
+			case string:
+				if v != "" {
+					return v
+				}
+			case float64:
+				return strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+		return ""
+	}
+
+	claims := &Claims{
+		Subject:  str("sub", "id"),
+		Username: str("preferred_username", "login"),
+		Name:     str("name"),
+		Email:    str("email"),
+	}
+	switch v := m["email_verified"].(type) {
+	case bool:
+		claims.EmailVerified = v
+	case string:
+		claims.EmailVerified = v == "true"
+	}
+	if claims.Username == "" {
+		claims.Username, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if claims.Username == "" {
+		claims.Username = claims.Subject
+	}
+
+	return claims
+}
+
+func (p *Provider) getJSON(ctx context.Context, url, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %v: unexpected status %v", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,120 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStateNotFound = errors.New("login state was not found or has expired")
+	ErrInvalidState  = errors.New("login state is invalid")
+)
+
+// State is kept on the server between sending the user to the provider and the callback
+type State struct {
+	Provider  string
+	Nonce     string
+	Verifier  string // PKCE code verifier
+	CreatedAt time.Time
+	// UserID and Action are set when a signed in user is sent to the provider to link it or to confirm an action
+	UserID string
+	Action string
+}
+
+// StateStore keeps login states, Take returns the state only once
+type StateStore interface {
+	Save(ctx context.Context, key string, state *State) error
+	Take(ctx context.Context, key string) (*State, error)
+}
+
+type memoryStateStore struct {
+	ttl time.Duration
+
+	mu     sync.Mutex
+	states map[string]*State
+}
+
+// NewMemoryStateStore keeps states in memory of the process for ttl
+func NewMemoryStateStore(ttl time.Duration) StateStore {
+	return &memoryStateStore{
+		ttl:    ttl,
+		states: map[string]*State{},
+	}
+}
+
+func (m *memoryStateStore) Save(ctx context.Context, key string, state *State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// forget abandoned logins
+	for k, s := range m.states {
+		if time.Since(s.CreatedAt) > m.ttl {
+			delete(m.states, k)
+		}
+	}
+	m.states[key] = state
+
+	return nil
+}
+
+func (m *memoryStateStore) Take(ctx context.Context, key string) (*State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.states[key]
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	delete(m.states, key)
+	if time.Since(state.CreatedAt) > m.ttl {
+		return nil, ErrStateNotFound
+	}
+
+	return state, nil
+}
+
+// SignState appends HMAC of the key to it, so states which were not issued by the server are rejected before lookup
+func SignState(secret, key string) string {
+	return key + "." + stateSignature(secret, key)
+}
+
+// VerifyState checks the signature and returns the key of the signed state
+func VerifyState(secret, signed string) (string, error) {
+	key, signature, ok := strings.Cut(signed, ".")
+	if !ok || key == "" || !hmac.Equal([]byte(signature), []byte(stateSignature(secret, key))) {
+		return "", ErrInvalidState
+	}
+
+	return key, nil
+}
+
+func stateSignature(secret, key string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("oauth-state:" + key))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// RandomString returns url safe random string of n random bytes, it is used for state, nonce and PKCE verifier
+func RandomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// CodeChallenge is S256 PKCE challenge of the verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,283 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Plan limits uploads of senders, zero limit means unlimited
+type Plan struct {
+	Name           string
+	DailyTransfers int64
+	MaxFileSize    int64 // bytes
+	MonthlyBytes   int64
+	MaxExpiry      time.Duration
+}
+
+// Store keeps counters of subjects per period
+type Store interface {
+	// GetUsage returns counters of the subject in the period, zeros when nothing was sent
+	GetUsage(ctx context.Context, subject, period string) (transfers, bytes, rejected int64, err error)
+	// AddUsage adds to counters of the subject in the period, they can be removed after expiresAt
+	AddUsage(ctx context.Context, subject, period string, transfers, bytes, rejected int64, expiresAt time.Time) error
+}
+
+var (
+	ErrDailyTransfers = errors.New("daily transfer limit reached")
+	ErrMonthlyBytes   = errors.New("monthly transfer volume used up")
+	ErrFileTooLarge   = errors.New("file is larger than the upload limit")
+)
+
+type Options struct {
+	Store Store
+	Plans []Plan
+	// names of plans given to senders by default
+	Anonymous  string // senders without account
+	Registered string // users without subdomain
+	Verified   string // users with subdomain
+	// MaxUploadSize limits files of all plans, uploads are kept in memory. Zero means no limit.
+	MaxUploadSize int64
+}
+
+type Quota struct {
+	store      Store
+	plans      map[string]Plan
+	names      []string
+	anonymous  string
+	registered string
+	verified   string
+	maxUpload  int64
+}
+
+// Sender is who uploads the file. Users are counted by their account, anonymous senders by both their
+// ip address and ssh key, so a new key does not give more transfers.
+type Sender struct {
+	IPAddress   string
+	Fingerprint string
+	UserID      string // empty for anonymous senders
+	Plan        string // plan assigned to the user by admins, empty for the default one
+	Verified    bool   // user has a subdomain
+}
+
+// Usage is what the sender sent today and this month, days and months are counted in UTC
+type Usage struct {
+	Plan      Plan
+	Transfers int64 // today
+	Bytes     int64 // this month
+	Rejected  int64 // uploads stopped today for breaking limits
+	maxUpload int64
+}
+
+func New(opt *Options) *Quota {
+	plans := make(map[string]Plan, len(opt.Plans))
+	names := make([]string, 0, len(opt.Plans))
+	for _, p := range opt.Plans {
+		if _, ok := plans[p.Name]; !ok {
+			names = append(names, p.Name)
+		}
+		plans[p.Name] = p
+	}
+
+	return &Quota{
+		store:      opt.Store,
+		plans:      plans,
+		names:      names,
+		anonymous:  opt.Anonymous,
+		registered: opt.Registered,
+		verified:   opt.Verified,
+		maxUpload:  opt.MaxUploadSize,
+	}
+}
+
+// Plans returns names of configured plans in the configured order
+func (q *Quota) Plans() []string {
+	if q == nil {
+		return nil
+	}
+
+	return q.names
+}
+
+// Plan returns plan of the sender, nil quota and unknown plans are unlimited
+func (q *Quota) Plan(s Sender) Plan {
+	if q == nil {
+		return Plan{}
+	}
+	if p, ok := q.plans[s.Plan]; ok && s.UserID != "" {
+		return p
+	}
+
+	name := q.anonymous
+	switch {
+	case s.UserID != "" && s.Verified:
+		name = q.verified
+	case s.UserID != "":
+		name = q.registered
+	}
+
+	p, ok := q.plans[name]
+	if !ok {
+		return Plan{Name: name}
+	}
+	return p
+}
+
+// Usage returns usage of the sender at the time, the largest counters of its subjects are taken
+func (q *Quota) Usage(ctx context.Context, s Sender, now time.Time) (*Usage, error) {
+	usage := &Usage{Plan: q.Plan(s)}
+	if q != nil {
+		usage.maxUpload = q.maxUpload
+	}
+	if q == nil || q.store == nil {
+		return usage, nil
+	}
+
+	day, _, month, _ := periods(now)
+	for _, subject := range s.subjects() {
+		transfers, _, rejected, err := q.store.GetUsage(ctx, subject, day)
+		if err != nil {
+			return nil, err
+		}
+		_, bytes, _, err := q.store.GetUsage(ctx, subject, month)
+		if err != nil {
+			return nil, err
+		}
+
+		if transfers > usage.Transfers {
+			usage.Transfers = transfers
+		}
+		if bytes > usage.Bytes {
+			usage.Bytes = bytes
+		}
+		if rejected > usage.Rejected {
+			usage.Rejected = rejected
+		}
+	}
+
+	return usage, nil
+}
+
+// Record counts the transfer of the sender
+func (q *Quota) Record(ctx context.Context, s Sender, bytes int64, now time.Time) error {
+	if q == nil || q.store == nil {
+		return nil
+	}
+
+	day, dayEnd, month, monthEnd := periods(now)
+	for _, subject := range s.subjects() {
+		if err := q.store.AddUsage(ctx, subject, day, 1, bytes, 0, dayEnd); err != nil {
+			return err
+		}
+		if err := q.store.AddUsage(ctx, subject, month, 1, bytes, 0, monthEnd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reject counts the upload of the sender which was stopped for breaking limits
+func (q *Quota) Reject(ctx context.Context, s Sender, now time.Time) error {
+	if q == nil || q.store == nil {
+		return nil
+	}
+
+	day, dayEnd, month, monthEnd := periods(now)
+	for _, subject := range s.subjects() {
+		if err := q.store.AddUsage(ctx, subject, day, 0, 0, 1, dayEnd); err != nil {
+			return err
+		}
+		if err := q.store.AddUsage(ctx, subject, month, 0, 0, 1, monthEnd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Check tells why the sender can not start one more transfer, nil when it can
+func (u *Usage) Check() error {
+	if u.Plan.DailyTransfers > 0 && u.Transfers >= u.Plan.DailyTransfers {
+		return ErrDailyTransfers
+	}
+	if u.Plan.MonthlyBytes > 0 && u.Bytes >= u.Plan.MonthlyBytes {
+		return ErrMonthlyBytes
+	}
+
+	return nil
+}
+
+// FileSizeLimit returns the file size limit of the plan cut to the upload limit of the server, zero means unlimited
+func (u *Usage) FileSizeLimit() int64 {
+	size := u.Plan.MaxFileSize
+	if u.maxUpload > 0 && (size == 0 || u.maxUpload < size) {
+		size = u.maxUpload
+	}
+
+	return size
+}
+
+// MaxFileSize returns how large the next file can be, FileSizeLimit and what is left of the month are both
+// applied. Zero means unlimited.
+func (u *Usage) MaxFileSize() int64 {
+	size := u.FileSizeLimit()
+	if u.Plan.MonthlyBytes > 0 {
+		left := u.Plan.MonthlyBytes - u.Bytes
+		if left < 1 {
+			left = 1
+		}
+		if size == 0 || left < size {
+			size = left
+		}
+	}
+
+	return size
+}
+
+// Expiry returns how long the file waits for downloads, requested time is cut to the limit of the plan
+func (u *Usage) Expiry(requested time.Duration) time.Duration {
+	if u.Plan.MaxExpiry > 0 && requested > u.Plan.MaxExpiry {
+		return u.Plan.MaxExpiry
+	}
+
+	return requested
+}
+
+func (s Sender) subjects() []string {
+	if s.UserID != "" {
+		return []string{"user:" + s.UserID}
+	}
+
+	subjects := []string{"ip:" + s.IPAddress}
+	if s.Fingerprint != "" {
+		subjects = append(subjects, "key:"+s.Fingerprint)
+	}
+	return subjects
+}
+
+// periods returns keys of the day and the month of the time and when they end
+func periods(now time.Time) (string, time.Time, string, time.Time) {
+	now = now.UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	return "day:" + dayStart.Format("2006-01-02"), dayStart.AddDate(0, 0, 1),
+		"month:" + monthStart.Format("2006-01"), monthStart.AddDate(0, 1, 0)
+}
+
+// FormatBytes prints size like 1.5 MB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// buckets and bans which are over are swept at most this often
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	last    time.Time
+	expires time.Time
+}
+
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	bans      map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore keeps buckets in memory of the process, limits are per instance of the server
+func NewMemoryStore() Store {
+	return &memoryStore{
+		buckets: map[string]*bucket{},
+		bans:    map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+func (m *memoryStore) Take(key string, rps float64, burst int, ttl time.Duration) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok || now.After(b.expires) {
+		b = &bucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rps)
+	b.last = now
+	b.expires = now.Add(ttl)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	return false, time.Duration((1 - b.tokens) / rps * float64(time.Second)), nil
+}
+
+func (m *memoryStore) Ban(key string, d time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bans[key] = m.now().Add(d)
+	return nil
+}
+
+func (m *memoryStore) BannedFor(key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	until, ok := m.bans[key]
+	if !ok {
+		return 0, nil
+	}
+	left := until.Sub(m.now())
+	if left <= 0 {
+		delete(m.bans, key)
+		return 0, nil
+	}
+
+	return left, nil
+}
+
+func (m *memoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		if now.After(b.expires) {
+			delete(m.buckets, key)
+		}
+	}
+	for key, until := range m.bans {
+		if now.After(until) {
+			delete(m.bans, key)
+		}
+	}
+}
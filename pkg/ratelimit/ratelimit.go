@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// Limit is a token bucket, Burst tokens at most and RPS tokens added every second.
+// Idle buckets are forgotten after TTL. Zero Burst turns the limit off.
+type Limit struct {
+	RPS   float64
+	Burst int
+	TTL   time.Duration
+}
+
+// Store keeps buckets and bans, it can be shared by instances of the server
+type Store interface {
+	// Take takes a token from the bucket of the key, it returns how long to wait for the next one when there is none
+	Take(key string, rps float64, burst int, ttl time.Duration) (bool, time.Duration, error)
+	Ban(key string, d time.Duration) error
+	// BannedFor returns how long the key stays banned, zero when it is not banned
+	BannedFor(key string) (time.Duration, error)
+}
+
+// Kinds of limited actions
+const (
+	SSHPerIP  = "ssh_ip"
+	SSHPerKey = "ssh_key"
+	Uploads   = "uploads"
+	Direct    = "direct"
+	Delete    = "delete"
+	Login     = "login"
+	Report    = "report"
+)
+
+type Options struct {
+	Store  Store
+	Limits map[string]Limit
+	// ip address which runs out of tokens more than Strikes.Burst times is banned for BanFor
+	Strikes Limit
+	BanFor  time.Duration
+}
+
+type Limiter struct {
+	store   Store
+	limits  map[string]Limit
+	strikes Limit
+	banFor  time.Duration
+}
+
+// Decision tells if the action is allowed, RetryAfter is set when it is not
+type Decision struct {
+	Allowed    bool
+	Banned     bool
+	RetryAfter time.Duration
+}
+
+func New(opt *Options) *Limiter {
+	if opt.Store == nil {
+		opt.Store = NewMemoryStore()
+	}
+
+	return &Limiter{
+		store:   opt.Store,
+		limits:  opt.Limits,
+		strikes: opt.Strikes,
+		banFor:  opt.BanFor,
+	}
+}
+
+// AllowIP is Allow for limits per ip address
+func (l *Limiter) AllowIP(kind, ip string) (Decision, error) {
+	return l.Allow(kind, ip, ip)
+}
+
+// Allow takes a token from the bucket of the key for the kind of action. Banned ip addresses are not allowed
+// anything, nil limiter allows everything.
+func (l *Limiter) Allow(kind, key, ip string) (Decision, error) {
+	if l == nil {
+		return Decision{Allowed: true}, nil
+	}
+
+	if ip != "" {
+		banned, err := l.store.BannedFor("ban:" + ip)
+		if err != nil {
+			return Decision{}, err
+		}
+		if banned > 0 {
+			return Decision{Banned: true, RetryAfter: banned}, nil
+		}
+	}
+
+	limit := l.limits[kind]
+	if limit.Burst == 0 || key == "" {
+		return Decision{Allowed: true}, nil
+	}
+
+	ok, retryAfter, err := l.store.Take(kind+":"+key, limit.RPS, limit.Burst, limit.TTL)
+	if err != nil || ok {
+		return Decision{Allowed: ok}, err
+	}
+
+	return l.strike(ip, retryAfter)
+}
+
+// strike counts the ip address running out of tokens and bans it when it happens too often
+func (l *Limiter) strike(ip string, retryAfter time.Duration) (Decision, error) {
+	if ip == "" || l.strikes.Burst == 0 || l.banFor <= 0 {
+		return Decision{RetryAfter: retryAfter}, nil
+	}
+
+	ok, _, err := l.store.Take("strikes:"+ip, l.strikes.RPS, l.strikes.Burst, l.strikes.TTL)
+	if err != nil || ok {
+		return Decision{RetryAfter: retryAfter}, err
+	}
+
+	if err := l.store.Ban("ban:"+ip, l.banFor); err != nil {
+		return Decision{}, err
+	}
+
+	return Decision{Banned: true, RetryAfter: l.banFor}, nil
+}
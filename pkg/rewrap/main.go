@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/SaidovZohid/swiftsend.it/config"
+	"github.com/SaidovZohid/swiftsend.it/pkg/blob"
+)
+
+// rewraps data keys of stored files with the current master key after it was rotated, run it with the same .env
+// as the server: "go run ./pkg/rewrap/main.go". Old keys can be removed from OLD_MASTER_KEYS once it succeeds.
+func main() {
+	cfg := config.Load()
+	if cfg.Scan.QuarantineDir == "" {
+		log.Fatal("SCAN_QUARANTINE_DIR is not set, there are no stored files")
+	}
+
+	keys, err := blob.NewKeyring(cfg.Encryption.MasterKeyID, cfg.Encryption.Keys())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := blob.NewStore(cfg.Scan.QuarantineDir, keys).RewrapAll()
+	fmt.Printf("%d files rewrapped with master key %q\n", n, keys.Current())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/config"
+	"github.com/SaidovZohid/swiftsend.it/pkg/hostkeys"
+)
+
+// starts rotation of ssh host keys, run it with the same .env as the server: "go run ./pkg/rotate_hostkeys/main.go".
+// Next keys are advertised to clients during SSH_HOST_KEY_ROTATION_WINDOW and the server switches to them after it,
+// there is no need to restart it.
+func main() {
+	cfg := config.Load()
+	if cfg.EncryptSecretKey == "" {
+		log.Fatal("ENCRYPT_SECRET_KEY is not set, ssh host keys are stored encrypted with it")
+	}
+
+	store := hostkeys.NewStore(cfg.HostKeys.Dir, []byte(cfg.EncryptSecretKey))
+	rotateAt, err := store.Rotate(cfg.HostKeys.RotationWindow, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := store.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, key := range keys.Fingerprints() {
+		if key.Next {
+			fmt.Printf("%s (%s)\n", key.SHA256, key.Type)
+		}
+	}
+	fmt.Println("these keys replace the current ones at", rotateAt.Format(time.RFC3339))
+}
@@ -0,0 +1,106 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// default size of INSTREAM chunks, clamd reads up to StreamMaxLength in total
+const clamdChunkSize = 64 << 10
+
+// Clamd sends files to ClamAV daemon with INSTREAM command
+type Clamd struct {
+	network string
+	address string
+}
+
+// NewClamd connects to clamd at tcp address like localhost:3310, or at unix socket when the address is a path
+func NewClamd(addr string) *Clamd {
+	network := "tcp"
+	addr = strings.TrimPrefix(addr, "tcp://")
+	if strings.HasPrefix(addr, "unix://") || strings.HasPrefix(addr, "/") {
+		network = "unix"
+		addr = strings.TrimPrefix(addr, "unix://")
+	}
+
+	return &Clamd{
+		network: network,
+		address: addr,
+	}
+}
+
+func (c *Clamd) Name() string {
+	return "clamd"
+}
+
+func (c *Clamd) Scan(ctx context.Context, r io.ReadSeeker) (*Result, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// null terminated command, the file follows as chunks with 4 byte length and zero length chunk at the end
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return nil, err
+	}
+	chunk := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return nil, err
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply reads replies like "stream: OK", "stream: Eicar-Signature FOUND" or "... ERROR"
+func parseClamdReply(reply string) (*Result, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	reply = strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case reply == "OK":
+		return &Result{Scanner: "clamd"}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &Result{
+			Infected: true,
+			Threat:   strings.TrimSuffix(reply, " FOUND"),
+			Scanner:  "clamd",
+		}, nil
+	default:
+		return nil, fmt.Errorf("clamd: %s", reply)
+	}
+}
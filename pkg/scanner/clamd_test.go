@@ -0,0 +1,188 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd answers INSTREAM like clamd does, reply decides the answer by the received file
+func fakeClamd(t *testing.T, reply func(file []byte) string) (string, <-chan []int) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	chunks := make(chan []int, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		cmd := make([]byte, len("zINSTREAM\x00"))
+		if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+			io.WriteString(conn, "UNKNOWN COMMAND\x00")
+			return
+		}
+
+		var (
+			file  bytes.Buffer
+			sizes []int
+		)
+		size := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(conn, size); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size)
+			if n == 0 {
+				break
+			}
+			sizes = append(sizes, int(n))
+			if _, err := io.CopyN(&file, conn, int64(n)); err != nil {
+				return
+			}
+		}
+		chunks <- sizes
+		io.WriteString(conn, reply(file.Bytes())+"\x00")
+	}()
+
+	return l.Addr().String(), chunks
+}
+
+func TestClamdScan(t *testing.T) {
+	eicar := []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`)
+	reply := func(file []byte) string {
+		switch {
+		case bytes.Equal(file, eicar):
+			return "stream: Eicar-Test-Signature FOUND"
+		case len(file) > 3*clamdChunkSize:
+			return "INSTREAM size limit exceeded. ERROR"
+		}
+		return "stream: OK"
+	}
+
+	tests := []struct {
+		name     string
+		file     []byte
+		infected bool
+		threat   string
+		wantErr  bool
+		chunks   []int
+	}{
+		{name: "clean", file: []byte("hello"), chunks: []int{5}},
+		{name: "empty", file: nil, chunks: nil},
+		{name: "infected", file: eicar, infected: true, threat: "Eicar-Test-Signature", chunks: []int{len(eicar)}},
+		{
+			name:   "chunked",
+			file:   bytes.Repeat([]byte("a"), 2*clamdChunkSize+10),
+			chunks: []int{clamdChunkSize, clamdChunkSize, 10},
+		},
+		{name: "error", file: bytes.Repeat([]byte("a"), 3*clamdChunkSize+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, chunks := fakeClamd(t, reply)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			res, err := NewClamd(addr).Scan(ctx, bytes.NewReader(tt.file))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Scan() = %+v, want error", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Infected != tt.infected || res.Threat != tt.threat || res.Scanner != "clamd" {
+				t.Errorf("Scan() = %+v, want infected %v threat %q", res, tt.infected, tt.threat)
+			}
+
+			sizes := <-chunks
+			if len(sizes) != len(tt.chunks) {
+				t.Fatalf("chunks = %v, want %v", sizes, tt.chunks)
+			}
+			for i := range sizes {
+				if sizes[i] != tt.chunks[i] {
+					t.Fatalf("chunks = %v, want %v", sizes, tt.chunks)
+				}
+			}
+		})
+	}
+}
+
+func TestClamdScanUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	if _, err := NewClamd(addr).Scan(context.Background(), strings.NewReader("hello")); err == nil {
+		t.Fatal("Scan() with clamd down returned no error")
+	}
+}
+
+func TestNewClamd(t *testing.T) {
+	tests := []struct {
+		addr, network, address string
+	}{
+		{"localhost:3310", "tcp", "localhost:3310"},
+		{"tcp://clamav:3310", "tcp", "clamav:3310"},
+		{"/run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl"},
+		{"unix:///run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl"},
+	}
+
+	for _, tt := range tests {
+		c := NewClamd(tt.addr)
+		if c.network != tt.network || c.address != tt.address {
+			t.Errorf("NewClamd(%q) = %s %s, want %s %s", tt.addr, c.network, c.address, tt.network, tt.address)
+		}
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply    string
+		infected bool
+		threat   string
+		wantErr  bool
+	}{
+		{reply: "stream: OK\x00"},
+		{reply: "OK\n"},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND\x00", infected: true, threat: "Win.Test.EICAR_HDB-1"},
+		{reply: "INSTREAM size limit exceeded. ERROR\x00", wantErr: true},
+		{reply: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		res, err := parseClamdReply(tt.reply)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseClamdReply(%q) = %+v, want error", tt.reply, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseClamdReply(%q) error: %v", tt.reply, err)
+			continue
+		}
+		if res.Infected != tt.infected || res.Threat != tt.threat {
+			t.Errorf("parseClamdReply(%q) = %+v, want infected %v threat %q", tt.reply, res, tt.infected, tt.threat)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+// Denylist finds files by sha256 hash of their content
+type Denylist struct {
+	hashes map[string]struct{}
+}
+
+func NewDenylist(hashes []string) *Denylist {
+	d := &Denylist{hashes: make(map[string]struct{}, len(hashes))}
+	for _, h := range hashes {
+		d.hashes[strings.ToLower(h)] = struct{}{}
+	}
+
+	return d
+}
+
+// LoadDenylist reads hashes from the file, one per line like output of sha256sum. Empty lines and lines
+// starting with # are skipped.
+func LoadDenylist(path string) (*Denylist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hashes []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		hashes = append(hashes, fields[0])
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewDenylist(hashes), nil
+}
+
+func (d *Denylist) Name() string {
+	return "denylist"
+}
+
+func (d *Denylist) Scan(ctx context.Context, r io.ReadSeeker) (*Result, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if _, ok := d.hashes[sum]; ok {
+		return &Result{
+			Infected: true,
+			Threat:   "denylisted file " + sum,
+			Scanner:  "denylist",
+		}, nil
+	}
+
+	return &Result{Scanner: "denylist"}, nil
+}
@@ -0,0 +1,78 @@
+package scanner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLoadDenylist(t *testing.T) {
+	bad := sha256Hex("bad file")
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	content := "# hashes of files which are never handed out\n\n" +
+		strings.ToUpper(bad) + "  bad.bin\n" +
+		"#" + sha256Hex("commented out") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := LoadDenylist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file     string
+		infected bool
+	}{
+		{"bad file", true},
+		{"good file", false},
+		{"commented out", false},
+	}
+	for _, tt := range tests {
+		res, err := d.Scan(context.Background(), strings.NewReader(tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Infected != tt.infected {
+			t.Errorf("Scan(%q) infected = %v, want %v", tt.file, res.Infected, tt.infected)
+		}
+		if res.Infected && res.Threat != "denylisted file "+bad {
+			t.Errorf("Scan(%q) threat = %q", tt.file, res.Threat)
+		}
+	}
+}
+
+func TestLoadDenylistMissing(t *testing.T) {
+	if _, err := LoadDenylist(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Fatal("LoadDenylist() of missing file returned no error")
+	}
+}
+
+func TestChainWithDenylist(t *testing.T) {
+	if Chain(nil, nil) != nil {
+		t.Fatal("Chain() without scanners is not nil")
+	}
+
+	chain := Chain(nil, NewDenylist([]string{sha256Hex("bad file")}))
+	// the reader is rewound before every scanner
+	r := strings.NewReader("bad file")
+	r.Seek(0, io.SeekEnd)
+	res, err := chain.Scan(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Infected || res.Scanner != "denylist" {
+		t.Errorf("Scan() = %+v, want denylist to find the file", res)
+	}
+}
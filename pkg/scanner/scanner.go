@@ -0,0 +1,58 @@
+package scanner
+
+import (
+	"context"
+	"io"
+)
+
+// Result of a scan, Threat names what was found in infected files
+type Result struct {
+	Infected bool
+	Threat   string
+	Scanner  string
+}
+
+// Scanner checks content of uploaded files
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, r io.ReadSeeker) (*Result, error)
+}
+
+type chain []Scanner
+
+// Chain runs scanners one by one until one of them finds a threat, nil scanners are skipped.
+// It returns nil when there is no scanner.
+func Chain(scanners ...Scanner) Scanner {
+	var c chain
+	for _, s := range scanners {
+		if s != nil {
+			c = append(c, s)
+		}
+	}
+	if len(c) == 0 {
+		return nil
+	}
+
+	return c
+}
+
+func (c chain) Name() string {
+	return "chain"
+}
+
+func (c chain) Scan(ctx context.Context, r io.ReadSeeker) (*Result, error) {
+	for _, s := range c {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		res, err := s.Scan(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		if res.Infected {
+			return res, nil
+		}
+	}
+
+	return &Result{}, nil
+}
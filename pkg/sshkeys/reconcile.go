@@ -0,0 +1,56 @@
+package sshkeys
+
+import (
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Key is a public key published by the provider
+type Key struct {
+	Type        string // like ssh-ed25519
+	Fingerprint string // SHA256 fingerprint without the "SHA256:" prefix, the way keys of users are kept
+}
+
+// Parse reads keys in authorized_keys format, lines which are not keys and repeated keys are skipped
+func Parse(lines []string) []Key {
+	keys := make([]Key, 0, len(lines))
+	seen := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		pubKey, _, _, _, err := gossh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			continue
+		}
+		fingerprint := strings.TrimPrefix(gossh.FingerprintSHA256(pubKey), "SHA256:")
+		if seen[fingerprint] {
+			continue
+		}
+		seen[fingerprint] = true
+		keys = append(keys, Key{Type: pubKey.Type(), Fingerprint: fingerprint})
+	}
+
+	return keys
+}
+
+// Reconcile compares the published keys with fingerprints of the keys synced before. It returns the published
+// keys which are not synced yet and the synced fingerprints which are not published anymore.
+func Reconcile(published []Key, synced []string) (added []Key, removed []string) {
+	isPublished := make(map[string]bool, len(published))
+	for _, key := range published {
+		isPublished[key.Fingerprint] = true
+	}
+	isSynced := make(map[string]bool, len(synced))
+	for _, fingerprint := range synced {
+		isSynced[fingerprint] = true
+		if !isPublished[fingerprint] {
+			removed = append(removed, fingerprint)
+		}
+	}
+	for _, key := range published {
+		if !isSynced[key.Fingerprint] {
+			added = append(added, key)
+		}
+	}
+
+	return added, removed
+}
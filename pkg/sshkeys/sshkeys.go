@@ -0,0 +1,122 @@
+package sshkeys
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// GithubURL is where GitHub publishes public keys of users as https://github.com/<login>.keys
+	GithubURL = "https://github.com"
+	// GithubAPIURL tells the current login of the user as https://api.github.com/user/<id>
+	GithubAPIURL = "https://api.github.com"
+)
+
+var ErrUserNotFound = errors.New("user was not found by keys provider")
+
+// Fetcher returns public keys of the user with the id at the provider in authorized_keys format, tests can use
+// a fake or a local http stub
+type Fetcher interface {
+	Fetch(ctx context.Context, id string) ([]string, error)
+}
+
+type githubFetcher struct {
+	baseURL string
+	apiURL  string
+	client  *http.Client
+}
+
+// NewGithubFetcher creates fetcher of <baseURL>/<login>.keys. Logins are given to someone else after a rename,
+// so the current login is looked up by the numeric id at <apiURL>/user/<id> first. Empty urls and nil client are
+// replaced with defaults.
+func NewGithubFetcher(baseURL, apiURL string, client *http.Client) Fetcher {
+	if baseURL == "" {
+		baseURL = GithubURL
+	}
+	if apiURL == "" {
+		apiURL = GithubAPIURL
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &githubFetcher{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiURL:  strings.TrimSuffix(apiURL, "/"),
+		client:  client,
+	}
+}
+
+func (f *githubFetcher) Fetch(ctx context.Context, id string) ([]string, error) {
+	login, err := f.login(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.get(ctx, f.baseURL+"/"+url.PathEscape(login)+".keys")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	keys := make([]string, 0)
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1<<20))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			keys = append(keys, line)
+		}
+	}
+
+	return keys, scanner.Err()
+}
+
+// login returns the current login of the user with the id
+func (f *githubFetcher) login(ctx context.Context, id string) (string, error) {
+	resp, err := f.get(ctx, f.apiURL+"/user/"+url.PathEscape(id))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&user); err != nil {
+		return "", err
+	}
+	if user.Login == "" {
+		return "", fmt.Errorf("user %v has no login", id)
+	}
+
+	return user.Login, nil
+}
+
+// get returns the response with status 200, missing users are ErrUserNotFound
+func (f *githubFetcher) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("get %v: unexpected status %v", rawURL, resp.Status)
+	}
+
+	return resp, nil
+}
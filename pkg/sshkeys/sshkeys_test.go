@@ -0,0 +1,146 @@
+package sshkeys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// newKey returns the public key in authorized_keys format and its fingerprint
+func newKey(t *testing.T) (string, string) {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(pubKey)))
+	return line, strings.TrimPrefix(gossh.FingerprintSHA256(pubKey), "SHA256:")
+}
+
+// githubStub serves logins by id like api.github.com and keys by login like github.com
+func githubStub(t *testing.T, logins map[string]string, keys map[string][]string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := strings.TrimPrefix(r.URL.Path, "/user/"); id != r.URL.Path {
+			login, ok := logins[id]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"login":"` + login + `","id":` + id + `}`))
+			return
+		}
+
+		lines, ok := keys[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".keys")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestGithubFetcherSync(t *testing.T) {
+	kept, keptFP := newKey(t)
+	added, addedFP := newKey(t)
+	_, removedFP := newKey(t)
+	// the old login of the user was taken by someone else after a rename
+	stranger, strangerFP := newKey(t)
+
+	srv := githubStub(t,
+		map[string]string{"42": "octocat-renamed"},
+		map[string][]string{
+			"octocat-renamed": {kept, "not a key", added, kept},
+			"octocat":         {stranger},
+		},
+	)
+	fetcher := NewGithubFetcher(srv.URL, srv.URL, srv.Client())
+
+	lines, err := fetcher.Fetch(context.Background(), "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := Parse(lines)
+	if len(published) != 2 || published[0].Fingerprint != keptFP || published[1].Fingerprint != addedFP {
+		t.Fatalf("Parse() = %+v, want the kept and the added key once", published)
+	}
+	if published[0].Type != gossh.KeyAlgoED25519 {
+		t.Errorf("Parse() type = %q, want %q", published[0].Type, gossh.KeyAlgoED25519)
+	}
+
+	toAdd, toRemove := Reconcile(published, []string{keptFP, removedFP, strangerFP})
+	if len(toAdd) != 1 || toAdd[0].Fingerprint != addedFP {
+		t.Errorf("Reconcile() added = %+v, want %s", toAdd, addedFP)
+	}
+	if len(toRemove) != 2 || toRemove[0] != removedFP || toRemove[1] != strangerFP {
+		t.Errorf("Reconcile() removed = %v, want %s and %s", toRemove, removedFP, strangerFP)
+	}
+}
+
+func TestGithubFetcherUserNotFound(t *testing.T) {
+	srv := githubStub(t,
+		map[string]string{"7": "deleted-keys"},
+		map[string][]string{},
+	)
+	fetcher := NewGithubFetcher(srv.URL, srv.URL, srv.Client())
+
+	for _, id := range []string{"404", "7"} {
+		if _, err := fetcher.Fetch(context.Background(), id); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Fetch(%q) error = %v, want ErrUserNotFound", id, err)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name      string
+		published []string
+		synced    []string
+		added     []string
+		removed   []string
+	}{
+		{name: "nothing synced yet", published: []string{"a", "b"}, added: []string{"a", "b"}},
+		{name: "in sync", published: []string{"a", "b"}, synced: []string{"b", "a"}},
+		{name: "all removed", synced: []string{"a", "b"}, removed: []string{"a", "b"}},
+		{name: "replaced", published: []string{"a", "c"}, synced: []string{"a", "b"}, added: []string{"c"}, removed: []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var published []Key
+			for _, fingerprint := range tt.published {
+				published = append(published, Key{Type: gossh.KeyAlgoED25519, Fingerprint: fingerprint})
+			}
+
+			added, removed := Reconcile(published, tt.synced)
+			var addedFPs []string
+			for _, key := range added {
+				addedFPs = append(addedFPs, key.Fingerprint)
+			}
+			if strings.Join(addedFPs, ",") != strings.Join(tt.added, ",") {
+				t.Errorf("added = %v, want %v", addedFPs, tt.added)
+			}
+			if strings.Join(removed, ",") != strings.Join(tt.removed, ",") {
+				t.Errorf("removed = %v, want %v", removed, tt.removed)
+			}
+		})
+	}
+}
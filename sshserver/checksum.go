@@ -0,0 +1,87 @@
+package sshserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/compress"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Checksum is the digest of the uploaded file, it is computed while the upload is read
+type Checksum struct {
+	SHA256  []byte
+	BLAKE2b []byte // BLAKE2b-512, nil when it is turned off
+}
+
+// SHA256Hex returns the sha256 checksum like sha256sum prints it
+func (c Checksum) SHA256Hex() string {
+	return hex.EncodeToString(c.SHA256)
+}
+
+// BLAKE2bHex returns the BLAKE2b-512 checksum like b2sum prints it, empty when it was not computed
+func (c Checksum) BLAKE2bHex() string {
+	return hex.EncodeToString(c.BLAKE2b)
+}
+
+// hasher feeds everything written to it into the checksums
+type hasher struct {
+	sha256  hash.Hash
+	blake2b hash.Hash
+}
+
+func newHasher(withBLAKE2b bool) *hasher {
+	h := &hasher{sha256: sha256.New()}
+	if withBLAKE2b {
+		// error is returned only for keys longer than 64 bytes
+		h.blake2b, _ = blake2b.New512(nil)
+	}
+
+	return h
+}
+
+func (h *hasher) Write(p []byte) (int, error) {
+	h.sha256.Write(p)
+	if h.blake2b != nil {
+		h.blake2b.Write(p)
+	}
+
+	return len(p), nil
+}
+
+func (h *hasher) Sum() Checksum {
+	sum := Checksum{SHA256: h.sha256.Sum(nil)}
+	if h.blake2b != nil {
+		sum.BLAKE2b = h.blake2b.Sum(nil)
+	}
+
+	return sum
+}
+
+// decodeUpload checks pre-compressed upload and computes checksum of its content instead of the compressed bytes,
+// so downloaders can check the file they extract
+func decodeUpload(pipe *Tunnel, withBLAKE2b bool) error {
+	opts := pipe.User.Options
+	if opts == nil || opts.Encoding == nil {
+		return nil
+	}
+	buf, ok := pipe.File.W.(*bytes.Buffer)
+	if !ok {
+		return errors.New("upload is not kept in memory")
+	}
+
+	sum := newHasher(withBLAKE2b)
+	size, err := compress.Gunzip(sum, buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("decoding %s upload: %w", *opts.Encoding, err)
+	}
+	pipe.File.Encoding = *opts.Encoding
+	pipe.File.ContentSize = size
+	pipe.File.Checksum = sum.Sum()
+
+	return nil
+}
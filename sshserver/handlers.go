@@ -8,18 +8,47 @@ import (
 	"strings"
 	"time"
 
-	"github.com/SaidovZohid/swiftsend.it/config"
+	"github.com/SaidovZohid/swiftsend.it/pkg/compress"
+	"github.com/SaidovZohid/swiftsend.it/pkg/links"
+	"github.com/SaidovZohid/swiftsend.it/pkg/quota"
+	"github.com/SaidovZohid/swiftsend.it/pkg/ratelimit"
+	"github.com/SaidovZohid/swiftsend.it/pkg/utils"
 	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
 	"github.com/gliderlabs/ssh"
 	"github.com/logrusorgru/aurora"
+	"github.com/skip2/go-qrcode"
 )
 
-const (
-	baseURI = "https://jtf.zohiddev.me"
-)
+// upper limit of "n=" option
+const maxDownloads = 100
+
+// exitTooLarge is the exit status of sessions whose file was larger than the limit
+const exitTooLarge = 3
+
+// sizeLimitReader counts bytes read from the session and fails the moment there are more than max
+type sizeLimitReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	// read at most one byte more than the limit
+	if left := l.max - l.n + 1; int64(len(p)) > left {
+		p = p[:left]
+	}
 
-// if io.Copy does not give and response in 5 seconds it returns error
-func performCopyOperation(session ssh.Session, pipe *Tunnel) error {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, quota.ErrFileTooLarge
+	}
+	return n, err
+}
+
+// if io.Copy does not give and response in 5 seconds it returns error. Files larger than maxSize are not read
+// to the end, zero maxSize means no limit. Checksum of the file is computed on the way.
+func performCopyOperation(session ssh.Session, pipe *Tunnel, maxSize int64, withBLAKE2b bool) error {
 	// Set timeout duration to 5 seconds
 	timeout := 3 * time.Second
 
@@ -29,11 +58,17 @@ func performCopyOperation(session ssh.Session, pipe *Tunnel) error {
 	// Start a goroutine to perform the copy operation
 	go func() {
 		// Perform the copy operation from `val.W` to the buffer
-		fileSize, err := io.Copy(pipe.File.W, session)
-		resultCh <- err
+		var r io.Reader = session
+		if maxSize > 0 {
+			r = &sizeLimitReader{r: session, max: maxSize}
+		}
+		sum := newHasher(withBLAKE2b)
+		fileSize, err := io.Copy(io.MultiWriter(pipe.File.W, sum), r)
 		if fileSize != 0 {
 			pipe.File.FileSize = fileSize
 		}
+		pipe.File.Checksum = sum.Sum()
+		resultCh <- err
 	}()
 
 	// Wait for the operation to complete or the timeout to elapse
@@ -42,7 +77,7 @@ func performCopyOperation(session ssh.Session, pipe *Tunnel) error {
 		// Operation completed before the timeout
 		if err != nil {
 			// Handle the error appropriately
-			return fmt.Errorf("error handling: %v", err)
+			return fmt.Errorf("error handling: %w", err)
 		}
 		// Success
 		return nil
@@ -53,7 +88,7 @@ func performCopyOperation(session ssh.Session, pipe *Tunnel) error {
 }
 
 // error handler writes to user session console!
-func writeErrorAndHowToUse(s ssh.Session) {
+func writeErrorAndHowToUse(s ssh.Session, maxSize int64) {
 	io.WriteString(s, "\n")
 	io.WriteString(s, "\t"+aurora.Red("🔵❗ JTF Error").String()+"\n\n")
 
@@ -65,7 +100,14 @@ func writeErrorAndHowToUse(s ssh.Session) {
 	- Add a personalized "msg=" to include a special message along with the file.
 	- Customize the "filename=" parameter to give your downloaded file a unique name.
 	- Set "t=" option (0<sv<60) during file upload to control download time.
+	- Add "qr=1" to print a QR code of the download link, "qr=0" hides it in interactive sessions.
+	- Set "n=" option (0<n<=100) to let the file be downloaded more than once.
+	- Protect the file with "pw=" option, downloaders must enter the password.
+	- Send gzip compressed file with "enc=gzip" option, it is served without compressing again.
 	`)
+	if maxSize > 0 {
+		io.WriteString(s, "\n\t- Files up to "+quota.FormatBytes(maxSize)+" can be sent with your plan.\n")
+	}
 
 	io.WriteString(s, "\n"+aurora.Green("💡 Did you know?").String()+"\n")
 	io.WriteString(s, "\t- You can even set multiple options together to create a highly customized experience. Feel free to explore the possibilities!\n")
@@ -78,27 +120,6 @@ func writeErrorAndHowToUse(s ssh.Session) {
 	io.WriteString(s, aurora.Yellow("✨ Get creative and enjoy using JTF! If you need any further assistance, don't hesitate to reach out. mailto='support@zohiddev.me'✨").String()+"\n")
 }
 
-// func handleUsageExceededNotUser(s ssh.Session) {
-// 	io.WriteString(s, "\n"+aurora.Red("\t❗ JTF 3 times usage exceeded ❗").String()+"\n\n")
-
-// 	io.WriteString(s, aurora.Blue("⚠️  Oops! Usage limit exceeded. Link your laptop's SSH key to continue. ⚡️").String()+"\n\n")
-// 	io.WriteString(s, "\t"+aurora.Green("🚀 New to JTF? Sign up at https://jtf.zohiddev.me/signup, get verified with a subdomain, and link your key for limitless access! 🔑✨").String()+"\n\n")
-// 	io.WriteString(s, "\t"+aurora.Green("🔗 Already a JTF member? Link your SSH key now at https://jtf.zohiddev.me/s/settings/keys/add for seamless file transfers! 🚀🔒").String()+"\n\n")
-
-// 	io.WriteString(s, aurora.Yellow("✨ Get creative and enjoy using JTF! If you need any further assistance, don't hesitate to reach out. mailto='support@zohiddev.me'✨").String()+"\n")
-// }
-
-// func handleUsageExceededNotSubdomain(s ssh.Session) {
-// 	io.WriteString(s, "\n"+aurora.Red("\t❗ JTF 3 times usage exceeded ❗").String()+"\n\n")
-
-// 	io.WriteString(s, aurora.Blue("⚠️  Oops! Usage limit exceeded. Get a subdomain to be verified user to continue. ⚡️").String()+"\n\n")
-// 	io.WriteString(s, "\t"+aurora.Blue("🚀 New to JTF? Sign up at https://jtf.zohiddev.me/signup, get verified with a subdomain, and link your key for limitless access! 🔑✨").String()+"\n\n")
-// 	io.WriteString(s, "\t🔗 Already a JTF member? Get a subdomain now at https://jtf.zohiddev.me/s/settings/account for seamless file transfers! 🚀🔒\n\n")
-
-// 	yellow := color.New(color.FgYellow)
-// 	yellow.Fprint(s, "✨ Get creative and enjoy using JTF! If you need any further assistance, don't hesitate to reach out. mailto='support@zohiddev.me'✨\n")
-// }
-
 func greatingHi(s ssh.Session) {
 	io.WriteString(s, "\t"+aurora.Green("🌟✨ Welcome to JTF! ✨🌟").String()+"\n\n")
 }
@@ -113,62 +134,139 @@ func handleDeleted(timer *time.Timer, s ssh.Session, pipe Tunnel) {
 	timer.Stop()
 }
 
+func handleKilled(timer *time.Timer, s ssh.Session) {
+	io.WriteString(s, aurora.Red("🛑 Your transfer was stopped by JTF administrators. ❌").String()+"\n")
+	timer.Stop()
+}
+
+func handleKeyExpired(s ssh.Session, lb *links.Builder) {
+	io.WriteString(s, "\n"+aurora.Red("\t🔑 JTF SSH key expired").String()+"\n\n")
+	io.WriteString(s, aurora.Blue("The SSH key you are using has expired. Change its expiry date at "+lb.Site()+"/s/settings/keys to keep using it.").String()+"\n")
+}
+
+func handleBanned(s ssh.Session) {
+	io.WriteString(s, "\n"+aurora.Red("\t⛔ JTF Access denied ⛔").String()+"\n\n")
+	io.WriteString(s, aurora.Blue("You are not allowed to send files with JTF. If you think it is a mistake, reach out to mailto='support@zohiddev.me'").String()+"\n")
+}
+
+func handleQuotaExceeded(s ssh.Session, lb *links.Builder, user *mongodb.User, usage *quota.Usage, err error) {
+	plan := usage.Plan.Name
+	io.WriteString(s, "\n"+aurora.Red("\t❗ JTF "+plan+" plan limit reached ❗").String()+"\n\n")
+
+	switch {
+	case errors.Is(err, quota.ErrDailyTransfers):
+		io.WriteString(s, aurora.Blue(fmt.Sprintf("⚠️  You sent %v files today, it is the limit of your plan. Try again tomorrow. ⚡️", usage.Transfers)).String()+"\n\n")
+	case errors.Is(err, quota.ErrMonthlyBytes):
+		io.WriteString(s, aurora.Blue("⚠️  You sent "+quota.FormatBytes(usage.Bytes)+" this month, it is the limit of your plan. Try again next month. ⚡️").String()+"\n\n")
+	case errors.Is(err, quota.ErrFileTooLarge):
+		io.WriteString(s, aurora.Blue("⚠️  The file is larger than "+quota.FormatBytes(usage.MaxFileSize())+", the upload was stopped. Your plan lets you send files up to this size now. ⚡️").String()+"\n\n")
+	}
+
+	switch {
+	case user == nil:
+		io.WriteString(s, "\t"+aurora.Green("🚀 New to JTF? Sign up at "+lb.Site()+"/signup, get verified with a subdomain, and link your key for larger limits! 🔑✨").String()+"\n\n")
+		io.WriteString(s, "\t"+aurora.Green("🔗 Already a JTF member? Link your SSH key now at "+lb.Site()+"/s/settings/keys/add 🚀🔒").String()+"\n\n")
+	case user.Subdomain == nil:
+		io.WriteString(s, "\t"+aurora.Green("🔗 Get a subdomain at "+lb.Site()+"/s/settings/account to be a verified user with larger limits! 🚀🔒").String()+"\n\n")
+	default:
+		io.WriteString(s, "\t"+aurora.Green("📊 See your usage at "+lb.Site()+"/s/settings/account").String()+"\n\n")
+	}
+
+	io.WriteString(s, aurora.Yellow("✨ If you need larger limits, don't hesitate to reach out. mailto='support@zohiddev.me'✨").String()+"\n")
+}
+
+func handleRateLimited(s ssh.Session, decision ratelimit.Decision) {
+	wait := decision.RetryAfter.Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+
+	if decision.Banned {
+		io.WriteString(s, "\n"+aurora.Red("\t⛔ JTF Too many requests ⛔").String()+"\n\n")
+		io.WriteString(s, aurora.Blue("Too many connections from your ip address, it is blocked for "+wait.String()+".").String()+"\n")
+		return
+	}
+
+	io.WriteString(s, "\n"+aurora.Yellow("\t⏳ JTF Slow down ⏳").String()+"\n\n")
+	io.WriteString(s, aurora.Blue("You are sending files too often, try again in "+wait.String()+".").String()+"\n")
+}
+
+func handleScanning(s ssh.Session) {
+	io.WriteString(s, aurora.Cyan("🔎 Your file is being checked for malware, the link works once the check is done.").String()+"\n\n")
+}
+
+func handleScanClean(s ssh.Session) {
+	io.WriteString(s, aurora.Green("✅ Your file passed the malware check, the link is live now.").String()+"\n")
+}
+
+func handleInfected(s ssh.Session, threat string) {
+	io.WriteString(s, "\n"+aurora.Red("\t☣️  JTF Malware found ☣️").String()+"\n\n")
+	io.WriteString(s, aurora.Blue("Your file was quarantined and the link was taken down. Found: "+threat).String()+"\n")
+	io.WriteString(s, aurora.Blue("If you think it is a mistake, reach out to mailto='support@zohiddev.me'").String()+"\n")
+}
+
+func handleScanFailed(s ssh.Session) {
+	io.WriteString(s, "\n"+aurora.Red("\t❗ JTF Malware check failed ❗").String()+"\n\n")
+	io.WriteString(s, aurora.Blue("Your file could not be checked for malware, so the link was taken down. Please try again later.").String()+"\n")
+}
+
 func handleNooneDownloaded(s ssh.Session) {
 	io.WriteString(s, aurora.Yellow("⏳ Time's up! No downloaded 😭. Keep sharing the link! 🔥").String()+"\n")
 }
 
-func handleUserHas(s ssh.Session, user *mongodb.User, cfg *config.Config, link string, pipe Tunnel) {
-	subdomainUrl := fmt.Sprintf(cfg.BaseURL+"/domain/%v/info", *user.Subdomain)
-	if cfg.BaseURL == baseURI {
-		subdomainUrl = fmt.Sprintf("https://%v."+cfg.BaseURL[8:], *user.Subdomain)
+func handleUserHas(s ssh.Session, user *mongodb.User, lb *links.Builder, link string, pipe Tunnel) {
+	subdomainUrl := lb.Subdomain(*user.Subdomain)
+	downloadLink := lb.Download(*user.Subdomain, link)
+	if pipe.User.CustomDomain != "" {
+		subdomainUrl = lb.Domain(pipe.User.CustomDomain)
+		downloadLink = subdomainUrl + "/" + link
 	}
 
 	io.WriteString(s, fmt.Sprintf("%v %v 🔒🌟\n\n", aurora.Green("🌟🔒 Detected verified user domain").String(), aurora.Cyan(subdomainUrl).Underline().String()))
 
-	handleLinkSent(s, cfg, link, user.Subdomain, pipe)
+	handleLinkSent(s, lb, link, downloadLink, pipe)
 }
 
-func handleUserNot(s ssh.Session, cfg *config.Config, link string, pipe Tunnel) {
+func handleUserNot(s ssh.Session, lb *links.Builder, link string, pipe Tunnel) {
 	io.WriteString(s, aurora.Yellow("💫 Discover the Power of JTF! Get your Own Verified Link Today! 🌟").String()+"\n")
 
-	io.WriteString(s, fmt.Sprintf("\nWant your own personal verified link %v?\n", aurora.Cyan("username.jtf.zohiddev.me").Underline().String()))
+	io.WriteString(s, fmt.Sprintf("\nWant your own personal verified link %v?\n", aurora.Cyan(lb.SubdomainHost("username")).Underline().String()))
 
-	io.WriteString(s, "\t"+aurora.Red("-> Visit https://jtf.zohiddev.me to get your verified subdomain, it's FREE and get UNLIMITED transfers").String()+"\n")
+	io.WriteString(s, "\t"+aurora.Red("-> Visit "+lb.Site()+" to get your verified subdomain, it's FREE and get UNLIMITED transfers").String()+"\n")
 
-	handleLinkSent(s, cfg, link, nil, pipe)
+	handleLinkSent(s, lb, link, lb.Download("", link), pipe)
 }
 
-func handleLinkSent(s ssh.Session, cfg *config.Config, link string, subdomain *string, pipe Tunnel) {
+func handleLinkSent(s ssh.Session, lb *links.Builder, link, downloadLink string, pipe Tunnel) {
 	// Download link to frontend page
 	io.WriteString(s, "Download link:\n")
-	downloadLink := cfg.BaseURL + "/download/unknown/" + link
-	if subdomain != nil {
-		downloadLink = cfg.BaseURL + "/download/" + *subdomain + "/" + link
-	}
-	if cfg.BaseURL == baseURI {
-		if subdomain == nil {
-			downloadLink = "https://" + "unknown." + cfg.BaseURL[8:] + "/" + link
-		} else {
-			downloadLink = "https://" + *subdomain + "." + cfg.BaseURL[8:] + "/" + link
-		}
-		// https://zohiddev.me
-	}
 	io.WriteString(s, "\t"+aurora.Yellow(downloadLink).String()+"\n")
 
 	// Direct download link
 	io.WriteString(s, "\nDirect download link:\n")
-	directLink := cfg.BaseURL + "/direct/" + link
-	if cfg.BaseURL == baseURI {
-		directLink = "https://direct." + cfg.BaseURL[8:] + "/" + link
-	}
-	io.WriteString(s, "\t"+aurora.Yellow(directLink).String()+"\n")
+	io.WriteString(s, "\t"+aurora.Yellow(lb.Direct(link)).String()+"\n")
 
 	io.WriteString(s, "\nDelete file link:\n")
-	deleteLink := cfg.BaseURL + "/delete/" + link
-	if cfg.BaseURL == baseURI {
-		deleteLink = "https://delete." + cfg.BaseURL[8:] + "/" + link
+	io.WriteString(s, "\t"+aurora.Red(lb.Delete(link)).String()+"\n")
+
+	io.WriteString(s, "\nSHA-256 checksum, check the download with \"sha256sum -c\" and "+lb.Direct(link)+".sha256:\n")
+	io.WriteString(s, "\t"+aurora.Green(pipe.File.Checksum.SHA256Hex()).String()+"\n")
+	if b2 := pipe.File.Checksum.BLAKE2bHex(); b2 != "" {
+		io.WriteString(s, "\nBLAKE2b-512 checksum:\n")
+		io.WriteString(s, "\t"+aurora.Green(b2).String()+"\n")
+	}
+
+	if pipe.User.Options.PasswordProtected() {
+		io.WriteString(s, "\nPassword:\n")
+		io.WriteString(s, "\t"+aurora.Magenta(*pipe.User.Options.Password).String()+"\n")
+	}
+	if n := pipe.User.Options.MaxDownloads(); n > 1 {
+		io.WriteString(s, fmt.Sprintf("\nThe file can be downloaded %v times.\n", n))
+	}
+
+	if wantsQRCode(s, pipe) {
+		writeQRCode(s, downloadLink)
 	}
-	io.WriteString(s, "\t"+aurora.Red(deleteLink).String()+"\n")
 
 	tm := fmt.Sprintf("%v minutes", 15)
 	if pipe.User.Options != nil && pipe.User.Options.Save != nil {
@@ -182,6 +280,32 @@ func handleLinkSent(s ssh.Session, cfg *config.Config, link string, subdomain *s
 	io.WriteString(s, "\n"+aurora.Cyan("⏳ Please hurry! Your link will expire in "+tm+". After that, the session will automatically close, and the link will become invalid. Let's patiently wait for the download to commence... 🕒").String()+"\n\n")
 }
 
+// QR code is shown when user asked with "qr=1" or when the session has a terminal, "qr=0" turns it off
+func wantsQRCode(s ssh.Session, pipe Tunnel) bool {
+	if pipe.User.Options != nil && pipe.User.Options.QR != nil {
+		return *pipe.User.Options.QR
+	}
+	_, _, isPty := s.Pty()
+
+	return isPty
+}
+
+func writeQRCode(s ssh.Session, link string) {
+	q, err := qrcode.New(link, qrcode.Low)
+	if err != nil {
+		return
+	}
+
+	code := q.ToSmallString(false)
+	// terminal of pty session is in raw mode, new line does not move the cursor to the start
+	if _, _, isPty := s.Pty(); isPty {
+		code = strings.ReplaceAll(code, "\n", "\r\n")
+	}
+
+	io.WriteString(s, "\nScan to open on your phone:\n")
+	io.WriteString(s, code)
+}
+
 func parseUserInput(input []string, pipe *Tunnel) error {
 	var (
 		msg, filename, from, lastKey string
@@ -224,6 +348,28 @@ func parseUserInput(input []string, pipe *Tunnel) error {
 					return errors.New("not true option")
 				}
 				save = val
+			case "qr":
+				val, err := strconv.ParseBool(value)
+				if err != nil {
+					return errors.New("not true option")
+				}
+				pipe.User.Options.QR = &val
+			case "n":
+				val, err := strconv.Atoi(value)
+				if err != nil || val < 1 || val > maxDownloads {
+					return errors.New("not true option")
+				}
+				pipe.User.Options.Downloads = &val
+			case "enc":
+				if value != compress.Gzip {
+					return errors.New("not true option")
+				}
+				pipe.User.Options.Encoding = &value
+			case "pw":
+				if value == "" {
+					return errors.New("not true option")
+				}
+				pipe.User.Options.Password = &value
 			default:
 				return errors.New("not true option")
 			}
@@ -254,10 +400,11 @@ func parseUserInput(input []string, pipe *Tunnel) error {
 		}
 	}
 
-	if save > 0 && save <= 60 {
+	if save != 0 {
+		if save < 0 || save > 60 {
+			return errors.New("not true option")
+		}
 		pipe.User.Options.Save = &save
-	} else {
-		return errors.New("not true option")
 	}
 	if filename != "" {
 		pipe.User.Options.Filename = &filename
@@ -271,3 +418,23 @@ func parseUserInput(input []string, pipe *Tunnel) error {
 
 	return nil
 }
+
+// applyKeyDefaults fills options which the uploader omitted with defaults of the ssh key
+func applyKeyDefaults(opts *UserOption, defaults *mongodb.KeyDefaults) {
+	if opts.From == nil && defaults.From != "" {
+		from := defaults.From
+		opts.From = &from
+	}
+	if opts.Save == nil && defaults.Save > 0 {
+		save := defaults.Save
+		opts.Save = &save
+	}
+	if opts.Downloads == nil && defaults.Downloads > 0 {
+		downloads := defaults.Downloads
+		opts.Downloads = &downloads
+	}
+	if opts.Password == nil && defaults.RequirePassword {
+		password := utils.GenerateRandomLink(10)
+		opts.Password = &password
+	}
+}
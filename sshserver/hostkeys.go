@@ -0,0 +1,129 @@
+package sshserver
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/pkg/hostkeys"
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// OpenSSH extensions which let clients learn all host keys of the server and update known_hosts,
+// see PROTOCOL of OpenSSH and UpdateHostKeys of ssh_config
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// hostKeysSentKey marks the connection which was sent the host keys already
+var hostKeysSentKey = &struct{ name string }{"hostkeys-sent"}
+
+// hostKeysRefreshInterval is how often the rotation of host keys is checked
+const hostKeysRefreshInterval = time.Minute
+
+// advertiseHostKeys sends the current and the next host keys to the client once per connection
+func advertiseHostKeys(ctx ssh.Context, keys *hostkeys.Keys) {
+	if ctx.Value(hostKeysSentKey) != nil {
+		return
+	}
+	ctx.SetValue(hostKeysSentKey, true)
+
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(gossh.Conn)
+	if !ok {
+		return
+	}
+	var payload []byte
+	for _, signer := range keys.Advertised() {
+		payload = appendString(payload, signer.PublicKey().Marshal())
+	}
+	if _, _, err := conn.SendRequest(hostKeysRequest, false, payload); err != nil {
+		log.Println("error while sending host keys:", err)
+	}
+}
+
+// proveHostKeys signs the session id with the keys the client asks about, so it can trust the keys it learned
+func proveHostKeys(keys *hostkeys.Keys) ssh.RequestHandler {
+	return func(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+		conn, ok := ctx.Value(ssh.ContextKeyConn).(gossh.ConnMetadata)
+		if !ok {
+			return false, nil
+		}
+
+		var proofs []byte
+		for rest := req.Payload; len(rest) > 0; {
+			publicKey, next, err := readString(rest)
+			if err != nil {
+				return false, nil
+			}
+			rest = next
+
+			signer := keys.Lookup(publicKey)
+			if signer == nil {
+				return false, nil
+			}
+			var data []byte
+			data = appendString(data, []byte(hostKeysProveRequest))
+			data = appendString(data, conn.SessionID())
+			data = appendString(data, publicKey)
+			sig, err := signHostKeyProof(signer, data)
+			if err != nil {
+				log.Println("error while proving host key:", err)
+				return false, nil
+			}
+			proofs = appendString(proofs, gossh.Marshal(sig))
+		}
+
+		return true, proofs
+	}
+}
+
+// signHostKeyProof signs rsa proofs with rsa-sha2-512 like OpenSSH does, ssh-rsa signatures are refused by clients
+func signHostKeyProof(signer gossh.Signer, data []byte) (*gossh.Signature, error) {
+	if signer.PublicKey().Type() == gossh.KeyAlgoRSA {
+		if algSigner, ok := signer.(gossh.AlgorithmSigner); ok {
+			return algSigner.SignWithAlgorithm(rand.Reader, data, gossh.KeyAlgoRSASHA512)
+		}
+	}
+
+	return signer.Sign(rand.Reader, data)
+}
+
+// refreshHostKeys replaces the host keys of the server once the rotation window is over
+func refreshHostKeys(server *ssh.Server, keys *hostkeys.Keys) {
+	for range time.Tick(hostKeysRefreshInterval) {
+		promoted, err := keys.Refresh(time.Now())
+		if err != nil {
+			log.Println("error while refreshing host keys:", err)
+			continue
+		}
+		if !promoted {
+			continue
+		}
+		// keys of the same type are replaced
+		for _, signer := range keys.Signers() {
+			server.AddHostKey(signer)
+		}
+		log.Println("SSH host keys are rotated")
+	}
+}
+
+func appendString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) ([]byte, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, errors.New("short string")
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(n) {
+		return nil, nil, errors.New("short string")
+	}
+
+	return b[4 : 4+n], b[4+n:], nil
+}
@@ -0,0 +1,158 @@
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/SaidovZohid/swiftsend.it/config"
+	"github.com/SaidovZohid/swiftsend.it/pkg/blob"
+	"github.com/SaidovZohid/swiftsend.it/pkg/compress"
+	"github.com/SaidovZohid/swiftsend.it/pkg/metrics"
+	"github.com/SaidovZohid/swiftsend.it/pkg/scanner"
+	"github.com/SaidovZohid/swiftsend.it/storage"
+	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
+	"github.com/gliderlabs/ssh"
+)
+
+// Scan statuses of uploaded files
+const (
+	ScanPending  = "scanning"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanFailed   = "failed"
+)
+
+// Scan is the scan of the uploaded file, copies of the tunnel share it
+type Scan struct {
+	mu     sync.RWMutex
+	status string
+	threat string
+}
+
+// Status returns status of the scan, files which are not scanned are clean
+func (s *Scan) Status() string {
+	if s == nil {
+		return ScanClean
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.status
+}
+
+// Threat returns what the scanner found in the infected file
+func (s *Scan) Threat() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.threat
+}
+
+func (s *Scan) set(status, threat string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = status
+	s.threat = threat
+}
+
+type scanOutcome struct {
+	result *scanner.Result
+	err    error
+	data   []byte
+}
+
+// startScan scans the uploaded file in the background, the outcome is sent to the channel once.
+// Content of gzip uploads is scanned, decodeUpload checked them and counted their size already.
+func startScan(scan scanner.Scanner, file File, maxSize int64, timeout time.Duration) <-chan scanOutcome {
+	ch := make(chan scanOutcome, 1)
+	buf, _ := file.W.(*bytes.Buffer)
+	var data []byte
+	if buf != nil {
+		data = buf.Bytes()
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		content := data
+		if file.Encoding == compress.Gzip {
+			if maxSize > 0 && file.ContentSize > maxSize {
+				ch <- scanOutcome{err: fmt.Errorf("decoded file is %d bytes, over the limit of %d", file.ContentSize, maxSize), data: data}
+				return
+			}
+			var decoded bytes.Buffer
+			if _, err := compress.Gunzip(&decoded, data); err != nil {
+				ch <- scanOutcome{err: err, data: data}
+				return
+			}
+			content = decoded.Bytes()
+		}
+
+		res, err := scan.Scan(ctx, bytes.NewReader(content))
+		ch <- scanOutcome{result: res, err: err, data: data}
+	}()
+
+	return ch
+}
+
+// finishScan hands the file out when it is clean, otherwise it takes the link down and tells the sender.
+// It returns false when the link was taken down.
+func finishScan(s ssh.Session, cfg *config.Config, strg storage.StorageI, blobs *blob.Store, pipes *Tunnels, link string, pipe Tunnel, outcome scanOutcome) bool {
+	switch {
+	case outcome.err != nil && cfg.Scan.FailOpen:
+		log.Println("scan of", link, "failed, the file is handed out:", outcome.err)
+		metrics.Scanned(metrics.ScanFailed)
+		pipe.Scan.set(ScanClean, "")
+		return true
+	case outcome.err != nil:
+		log.Println("scan of", link, "failed:", outcome.err)
+		metrics.Scanned(metrics.ScanFailed)
+		pipe.Scan.set(ScanFailed, "")
+		pipes.Delete(link)
+		finishTransfer(strg, link, mongodb.TransferScanFailed)
+		handleScanFailed(s)
+		return false
+	case outcome.result.Infected:
+		metrics.Scanned(metrics.ScanInfected)
+		pipe.Scan.set(ScanInfected, outcome.result.Threat)
+		pipes.Delete(link)
+		file, err := quarantine(blobs, link, outcome.data)
+		if err != nil {
+			log.Println(err)
+		}
+		if err := strg.Transfer().QuarantineTransfer(context.Background(), link, outcome.result.Threat, file); err != nil {
+			log.Println(err)
+		}
+		handleInfected(s, outcome.result.Threat)
+		return false
+	default:
+		metrics.Scanned(metrics.ScanClean)
+	}
+
+	pipe.Scan.set(ScanClean, "")
+	handleScanClean(s)
+	return true
+}
+
+// quarantine keeps the infected file encrypted for review and returns its name, it is only dropped when there is
+// no quarantine directory
+func quarantine(blobs *blob.Store, link string, data []byte) (string, error) {
+	if blobs == nil {
+		return "", nil
+	}
+
+	name := time.Now().UTC().Format("20060102T150405") + "-" + link
+	if err := blobs.Put(name, data); err != nil {
+		return "", err
+	}
+	return name, nil
+}
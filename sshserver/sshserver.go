@@ -11,6 +11,13 @@ import (
 	"time"
 
 	"github.com/SaidovZohid/swiftsend.it/config"
+	"github.com/SaidovZohid/swiftsend.it/pkg/blob"
+	"github.com/SaidovZohid/swiftsend.it/pkg/hostkeys"
+	"github.com/SaidovZohid/swiftsend.it/pkg/links"
+	"github.com/SaidovZohid/swiftsend.it/pkg/metrics"
+	"github.com/SaidovZohid/swiftsend.it/pkg/quota"
+	"github.com/SaidovZohid/swiftsend.it/pkg/ratelimit"
+	"github.com/SaidovZohid/swiftsend.it/pkg/scanner"
 	"github.com/SaidovZohid/swiftsend.it/pkg/utils"
 	"github.com/SaidovZohid/swiftsend.it/storage"
 	"github.com/SaidovZohid/swiftsend.it/storage/mongodb"
@@ -23,36 +30,72 @@ type Tunnel struct {
 	File       File
 	DoneChan   chan struct{}
 	DeleteChan chan struct{}
+	ExpireChan chan struct{}
+	KillChan   chan struct{} // closed when an admin stops the transfer
 	SentAt     time.Time
 	ExpiresAt  time.Time
+	Downloads  int // finished downloads of the file
 	User       *User
+	Scan       *Scan // nil when uploads are not scanned
+	Frozen     bool  // reported link waits for review of admins
+}
+
+// Downloadable tells if the file can be handed out, frozen links and files which are not found clean are not
+func (p Tunnel) Downloadable() bool {
+	return !p.Frozen && p.Scan.Status() == ScanClean
 }
 
 type File struct {
 	W        io.Writer
 	FileSize int64
+	Checksum Checksum // of the decoded content
+	Encoding string   // content coding of pre-compressed uploads, empty when the bytes are the file itself
+	// ContentSize is the size of the decoded content of pre-compressed uploads
+	ContentSize int64
 }
 
 type User struct {
-	Subdomain string
-	Options   *UserOption
+	Subdomain    string
+	CustomDomain string // verified custom domain of the user, links are printed on it
+	IPAddress    string
+	Fingerprint  string
+	Options      *UserOption
 }
 
 type UserOption struct {
-	From     *string
-	Filename *string
-	Message  *string
-	Save     *int
+	From      *string
+	Filename  *string
+	Message   *string
+	Save      *int
+	QR        *bool
+	Downloads *int    // how many times the file can be downloaded, once when nil
+	Password  *string // downloaders must enter it
+	Encoding  *string // the upload is compressed by the sender, only gzip is known
+}
+
+// MaxDownloads returns how many times the file can be downloaded
+func (o *UserOption) MaxDownloads() int {
+	if o == nil || o.Downloads == nil {
+		return 1
+	}
+
+	return *o.Downloads
+}
+
+// PasswordProtected tells if downloaders must enter password
+func (o *UserOption) PasswordProtected() bool {
+	return o != nil && o.Password != nil
 }
 
 // ListenAndServer configures ssh key with private key of server and start ssh server
-func ListenAndServe(privateKey gossh.Signer, cfg *config.Config, pipes map[string]Tunnel, strg storage.StorageI) error {
+func ListenAndServe(hostKeys *hostkeys.Keys, cfg *config.Config, pipes *Tunnels, strg storage.StorageI, lb *links.Builder, limiter *ratelimit.Limiter, quotas *quota.Quota, scan scanner.Scanner, blobs *blob.Store) error {
 	var tunnel Tunnel
 	// Configure the SSH server
 	server := ssh.Server{
 		Addr: cfg.SshPort,
 		Handler: func(s ssh.Session) {
-			tunnel.HandleSSH(s, cfg, pipes, strg)
+			advertiseHostKeys(s.Context(), hostKeys)
+			tunnel.HandleSSH(s, cfg, pipes, strg, lb, limiter, quotas, scan, blobs)
 		},
 		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) bool {
 			// Add your logic here to validate the client's public key
@@ -61,32 +104,31 @@ func ListenAndServe(privateKey gossh.Signer, cfg *config.Config, pipes map[strin
 			// For simplicity, this example allows any client to connect
 			return key.Type() != "dsdsd"
 		},
+		RequestHandlers: map[string]ssh.RequestHandler{
+			hostKeysProveRequest: proveHostKeys(hostKeys),
+		},
 	}
 
-	// Set the server private key
-	server.AddHostKey(privateKey)
+	// Set the server private keys, next keys replace them after the rotation
+	for _, signer := range hostKeys.Signers() {
+		server.AddHostKey(signer)
+	}
+	go refreshHostKeys(&server, hostKeys)
 	log.Println("SSH server listening on port:", cfg.SshPort)
 
 	return server.ListenAndServe()
 }
 
-func (p *Tunnel) HandleSSH(session ssh.Session, cfg *config.Config, pipes map[string]Tunnel, strg storage.StorageI) {
+func (p *Tunnel) HandleSSH(session ssh.Session, cfg *config.Config, pipes *Tunnels, strg storage.StorageI, lb *links.Builder, limiter *ratelimit.Limiter, quotas *quota.Quota, scan scanner.Scanner, blobs *blob.Store) {
 	// Extracting the IP address from the connection
 	userIP, _, _ := net.SplitHostPort(session.RemoteAddr().String())
 
-	// usage, err := strg.Usage().GetUsage(context.Background(), userIP)
-	// if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
-	// 	log.Println(err)
-	// 	writeErrorAndHowToUse(session)
-	// 	return
-	// }
-
 	marshaledPublicKey := session.PublicKey().Marshal()
 	// Parse the SSH authorized key
 	pubKey, err := ssh.ParsePublicKey(marshaledPublicKey)
 	if err != nil {
 		log.Println(err)
-		writeErrorAndHowToUse(session)
+		writeErrorAndHowToUse(session, 0)
 		return
 	}
 
@@ -95,79 +137,156 @@ func (p *Tunnel) HandleSSH(session ssh.Session, cfg *config.Config, pipes map[st
 	user, err := strg.User().GetUserInfoByHashSSH(context.Background(), fingerprint)
 	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
 		log.Println(err)
-		writeErrorAndHowToUse(session)
+		writeErrorAndHowToUse(session, 0)
 		return
 	}
 
-	// if usage != nil {
-	// 	if usage.Usage >= 3 {
-	// 		if user == nil {
-	// 			handleUsageExceededNotUser(session)
-	// 			return
-	// 		} else if user.Subdomain == nil {
-	// 			handleUsageExceededNotSubdomain(session)
-	// 			return
-	// 		}
-	// 	}
-	// }
+	// the key which opened the session, it carries upload defaults of the user
+	var key *mongodb.Keys
+	if user != nil {
+		for i := range user.Keys {
+			if user.Keys[i].SSHHash == fingerprint {
+				key = &user.Keys[i]
+				break
+			}
+		}
+	}
+	if key != nil && key.Expired(time.Now()) {
+		handleKeyExpired(session, lb)
+		return
+	}
+	if key != nil {
+		if err := strg.User().TouchKey(context.Background(), fingerprint, userIP); err != nil {
+			log.Println(err)
+		}
+	}
 
-	// Create a fixed time zone for GMT+5 (Asia/Tashkent)
-	timezone := time.FixedZone("GMT+5", 5*60*60) // 5 hours ahead of UTC
+	banned, err := isBanned(strg, userIP, fingerprint, user)
+	if err != nil {
+		log.Println(err)
+		writeErrorAndHowToUse(session, 0)
+		return
+	}
+	if banned {
+		handleBanned(session)
+		return
+	}
+	if !allowSSH(session, limiter, userIP, fingerprint) {
+		return
+	}
 
-	var link string
-	for {
-		link = utils.GenerateRandomLink(7)
-		if _, ok := pipes[link]; !ok {
-			break
-		}
+	sender := quota.Sender{
+		IPAddress:   userIP,
+		Fingerprint: fingerprint,
+	}
+	if user != nil {
+		sender.UserID = user.Id.Hex()
+		sender.Plan = user.Plan
+		sender.Verified = user.Subdomain != nil
+	}
+	usage, err := quotas.Usage(context.Background(), sender, time.Now())
+	if err != nil {
+		log.Println(err)
+		writeErrorAndHowToUse(session, 0)
+		return
+	}
+	if err := usage.Check(); err != nil {
+		rejectUpload(session, lb, quotas, sender, user, usage, err)
+		return
 	}
 
+	// Create a fixed time zone for GMT+5 (Asia/Tashkent)
+	timezone := time.FixedZone("GMT+5", 5*60*60) // 5 hours ahead of UTC
+
 	timeNow := time.Now().In(timezone)
 
-	pipes[link] = Tunnel{
+	pipe := Tunnel{
 		File: File{
 			W:        &bytes.Buffer{}, // Use a bytes.Buffer as the io.Writer
 			FileSize: 0,
 		},
 		DoneChan:   make(chan struct{}),
 		DeleteChan: make(chan struct{}),
+		ExpireChan: make(chan struct{}),
+		KillChan:   make(chan struct{}),
 		SentAt:     timeNow,
 		ExpiresAt:  timeNow.Add(time.Minute * 15),
 		User: &User{
-			Subdomain: "",
-			Options:   &UserOption{},
+			Subdomain:   "",
+			IPAddress:   userIP,
+			Fingerprint: fingerprint,
+			Options:     &UserOption{},
 		},
 	}
-	pipe := pipes[link]
+	var link string
+	for {
+		link = utils.GenerateRandomLink(7)
+		if pipes.Add(link, pipe) {
+			break
+		}
+	}
 
 	// Copy the data from val.W to the buffer
-	err = performCopyOperation(session, &pipe)
+	err = performCopyOperation(session, &pipe, usage.MaxFileSize(), cfg.ChecksumBLAKE2b)
+	if errors.Is(err, quota.ErrFileTooLarge) {
+		pipes.Delete(link)
+		rejectUpload(session, lb, quotas, sender, user, usage, err)
+		return
+	}
 	if err != nil {
-		writeErrorAndHowToUse(session)
-		delete(pipes, link)
+		writeErrorAndHowToUse(session, usage.MaxFileSize())
+		pipes.Delete(link)
 		return
 	}
 
+	// the link stays in scanning state until the scanner finds the file clean
+	if scan != nil {
+		pipe.Scan = &Scan{status: ScanPending}
+		pipes.Update(link, func(t *Tunnel) { t.Scan = pipe.Scan })
+	}
+
 	// [from=Alex msg=Hello, John! Heres your special file filename=main.txt]
 	if session.Command() != nil {
 		parts := strings.Fields(session.RawCommand())
 		err = parseUserInput(parts, &pipe)
 		if err != nil {
-			writeErrorAndHowToUse(session)
+			writeErrorAndHowToUse(session, usage.MaxFileSize())
+			pipes.Delete(link)
 			return
 		}
-	} else {
+	} else if key == nil || key.Defaults == nil {
 		pipe.User.Options = nil
 	}
+	if key != nil && key.Defaults != nil {
+		applyKeyDefaults(pipe.User.Options, key.Defaults)
+	}
+	if err := decodeUpload(&pipe, cfg.ChecksumBLAKE2b); err != nil {
+		log.Println(err)
+		writeErrorAndHowToUse(session, usage.MaxFileSize())
+		pipes.Delete(link)
+		return
+	}
+	// pre-compressed uploads are scanned once they are decoded, so the scanner sees the file itself
+	var scanned <-chan scanOutcome
+	if scan != nil {
+		scanned = startScan(scan, pipe.File, usage.MaxFileSize(), cfg.Scan.Timeout)
+	}
+	limitExpiry(&pipe, usage, cfg.TimerForSSH)
 
 	// greeting
 	greatingHi(session)
 
 	if user != nil && user.Subdomain != nil {
 		pipe.User.Subdomain = *user.Subdomain
-		handleUserHas(session, user, cfg, link, pipe)
+		if user.CustomDomain != nil && user.CustomDomain.Verified {
+			pipe.User.CustomDomain = user.CustomDomain.Domain
+		}
+		handleUserHas(session, user, lb, link, pipe)
 	} else {
-		handleUserNot(session, cfg, link, pipe)
+		handleUserNot(session, lb, link, pipe)
+	}
+	if pipe.Scan != nil {
+		handleScanning(session)
 	}
 
 	// Calculate the time to wait for 15 minutes
@@ -184,33 +303,165 @@ func (p *Tunnel) HandleSSH(session ssh.Session, cfg *config.Config, pipes map[st
 		waitTime = timeNow.Add(cfg.TimerForSSH)
 	}
 
+	// keep the real expiry time in the shared tunnel so the download page can count it down
+	pipe.ExpiresAt = waitTime
+	pipes.Update(link, func(t *Tunnel) {
+		t.File, t.User, t.ExpiresAt = pipe.File, pipe.User, pipe.ExpiresAt
+	})
+
 	// Start a timer to wait for 15 minutes or user option from 1 minute to 60 minute acceptable
 	timer := time.NewTimer(waitTime.Sub(timeNow))
 
-	_, err = strg.Usage().CreateUsage(context.Background(), &mongodb.Usage{
-		IPAddress: userIP,
-		Usage:     1,
-	})
-	if err != nil {
+	metrics.Uploaded(pipe.File.FileSize)
+	if err := quotas.Record(context.Background(), sender, pipe.File.FileSize, timeNow); err != nil {
+		log.Println(err)
+	}
+
+	transfer := &mongodb.Transfer{
+		Link:        link,
+		Subdomain:   pipe.User.Subdomain,
+		Fingerprint: fingerprint,
+		IPAddress:   userIP,
+		Size:        pipe.File.FileSize,
+		SHA256:      pipe.File.Checksum.SHA256Hex(),
+		SentAt:      timeNow,
+		ExpiresAt:   waitTime,
+	}
+	if user != nil {
+		transfer.UserID = user.Id.Hex()
+	}
+	if pipe.User.Options != nil && pipe.User.Options.Filename != nil {
+		transfer.Filename = *pipe.User.Options.Filename
+	}
+	if err := strg.Transfer().CreateTransfer(context.Background(), transfer); err != nil {
 		log.Println(err)
-		writeErrorAndHowToUse(session)
-		delete(pipes, link)
-		return
 	}
 
 	// Wait for either the timer to expire or the DoneChan to be closed
-	select {
-	case <-timer.C:
-		// Timer expired, close the DoneChan
-		close(pipe.DoneChan)
-		delete(pipes, link)
-		handleNooneDownloaded(session)
-		return
-	case <-pipe.DoneChan:
-		handleFinished(timer, session, pipe)
-		return
-	case <-pipe.DeleteChan:
-		handleDeleted(timer, session, pipe)
+	for {
+		select {
+		case <-timer.C:
+			// the link was taken down at the same time, its channel tells how
+			if _, ok := pipes.Delete(link); !ok {
+				continue
+			}
+			// Timer expired, close the ExpireChan
+			close(pipe.ExpireChan)
+			finishTransfer(strg, link, mongodb.TransferExpired)
+			handleNooneDownloaded(session)
+			return
+		case <-pipe.DoneChan:
+			finishTransfer(strg, link, mongodb.TransferDownloaded)
+			handleFinished(timer, session, pipe)
+			return
+		case <-pipe.DeleteChan:
+			finishTransfer(strg, link, mongodb.TransferDeleted)
+			handleDeleted(timer, session, pipe)
+			return
+		case <-pipe.KillChan:
+			finishTransfer(strg, link, mongodb.TransferKilled)
+			handleKilled(timer, session)
+			return
+		case outcome := <-scanned:
+			// receiving from nil channel blocks, the result comes only once
+			scanned = nil
+			if !finishScan(session, cfg, strg, blobs, pipes, link, pipe, outcome) {
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+// isBanned checks the ip address, key fingerprint and subdomain of the sender
+func isBanned(strg storage.StorageI, ip, fingerprint string, user *mongodb.User) (bool, error) {
+	checks := map[string]string{
+		mongodb.BanIP:          ip,
+		mongodb.BanFingerprint: fingerprint,
+	}
+	if user != nil && user.Subdomain != nil {
+		checks[mongodb.BanSubdomain] = *user.Subdomain
+	}
+
+	for kind, value := range checks {
+		banned, err := strg.Ban().IsBanned(context.Background(), kind, value)
+		if err != nil || banned {
+			return banned, err
+		}
+	}
+
+	return false, nil
+}
+
+func finishTransfer(strg storage.StorageI, link, status string) {
+	if err := strg.Transfer().FinishTransfer(context.Background(), link, status); err != nil {
+		log.Println(err)
+	}
+}
+
+// rejectUpload tells the sender which limit stopped the upload, and counts it in metrics and usage of the sender
+func rejectUpload(s ssh.Session, lb *links.Builder, quotas *quota.Quota, sender quota.Sender, user *mongodb.User, usage *quota.Usage, err error) {
+	reason := metrics.RejectedDailyTransfers
+	switch {
+	case errors.Is(err, quota.ErrMonthlyBytes):
+		reason = metrics.RejectedMonthlyBytes
+	case errors.Is(err, quota.ErrFileTooLarge):
+		reason = metrics.RejectedTooLarge
+	}
+	metrics.Rejected(reason)
+	if err := quotas.Reject(context.Background(), sender, time.Now()); err != nil {
+		log.Println(err)
+	}
+
+	handleQuotaExceeded(s, lb, user, usage, err)
+	if errors.Is(err, quota.ErrFileTooLarge) {
+		s.Exit(exitTooLarge)
+	}
+}
+
+// limitExpiry cuts the time the file waits for downloads to the limit of the plan
+func limitExpiry(pipe *Tunnel, usage *quota.Usage, def time.Duration) {
+	requested := def
+	if pipe.User.Options != nil && pipe.User.Options.Save != nil {
+		requested = time.Duration(*pipe.User.Options.Save) * time.Minute
+	}
+	expiry := usage.Expiry(requested)
+	if expiry == requested {
 		return
 	}
+
+	minutes := int(expiry / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	if pipe.User.Options == nil {
+		pipe.User.Options = &UserOption{}
+	}
+	pipe.User.Options.Save = &minutes
+}
+
+// allowSSH takes tokens for the connection and the upload, limited senders are told when to come back.
+// Limits being unavailable do not stop uploads.
+func allowSSH(s ssh.Session, limiter *ratelimit.Limiter, ip, fingerprint string) bool {
+	checks := []struct {
+		kind string
+		key  string
+	}{
+		{ratelimit.SSHPerIP, ip},
+		{ratelimit.SSHPerKey, fingerprint},
+		{ratelimit.Uploads, ip},
+	}
+	for _, check := range checks {
+		decision, err := limiter.Allow(check.kind, check.key, ip)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !decision.Allowed {
+			handleRateLimited(s, decision)
+			return false
+		}
+	}
+
+	return true
 }
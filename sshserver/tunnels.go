@@ -0,0 +1,72 @@
+package sshserver
+
+import "sync"
+
+// Tunnels keeps the live transfers by their links, ssh sessions and http handlers change them concurrently
+type Tunnels struct {
+	mu      sync.RWMutex
+	tunnels map[string]Tunnel
+}
+
+func NewTunnels() *Tunnels {
+	return &Tunnels{tunnels: make(map[string]Tunnel)}
+}
+
+// Add stores the tunnel under the link, it returns false when the link is taken already
+func (t *Tunnels) Add(link string, tunnel Tunnel) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.tunnels[link]; ok {
+		return false
+	}
+	t.tunnels[link] = tunnel
+	return true
+}
+
+// Get returns a copy of the tunnel, changes to it are not seen by others until they are made with Update
+func (t *Tunnels) Get(link string) (Tunnel, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tunnel, ok := t.tunnels[link]
+	return tunnel, ok
+}
+
+// Update changes the tunnel under the lock and returns the changed copy, it returns false when there is no tunnel
+// with the link
+func (t *Tunnels) Update(link string, update func(*Tunnel)) (Tunnel, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tunnel, ok := t.tunnels[link]
+	if !ok {
+		return Tunnel{}, false
+	}
+	update(&tunnel)
+	t.tunnels[link] = tunnel
+	return tunnel, true
+}
+
+// Delete removes the tunnel and returns it. Only one caller gets true for the link, so it is the one which closes
+// the channel that tells the sender how the transfer ended.
+func (t *Tunnels) Delete(link string) (Tunnel, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tunnel, ok := t.tunnels[link]
+	delete(t.tunnels, link)
+	return tunnel, ok
+}
+
+// Snapshot returns copies of all tunnels
+func (t *Tunnels) Snapshot() map[string]Tunnel {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tunnels := make(map[string]Tunnel, len(t.tunnels))
+	for link, tunnel := range t.tunnels {
+		tunnels[link] = tunnel
+	}
+	return tunnels
+}
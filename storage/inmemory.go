@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"strconv"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -13,6 +15,11 @@ type InMemoryStorageI interface {
 	// GetAnother(key int64, text string) (string, error)
 	// Delete(key int64, text string) error
 	// DeleteWithoutTxt(key int64) error
+
+	// rate limit buckets and temporary bans, shared by all instances of the server
+	Take(key string, rps float64, burst int, ttl time.Duration) (bool, time.Duration, error)
+	Ban(key string, d time.Duration) error
+	BannedFor(key string) (time.Duration, error)
 }
 
 type storageRedis struct {
@@ -41,3 +48,63 @@ func (rd *storageRedis) Get(key string) ([]byte, error) {
 
 	return []byte(val), nil
 }
+
+// takeToken refills the bucket for the time passed since the last take and takes a token, atomically
+var takeToken = redis.NewScript(`
+local rps = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rps)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], ttl)
+return {allowed, tostring(tokens)}
+`)
+
+func (rd *storageRedis) Take(key string, rps float64, burst int, ttl time.Duration) (bool, time.Duration, error) {
+	now := float64(time.Now().UnixMicro()) / 1e6
+	res, err := takeToken.Run(context.Background(), rd.client, []string{"ratelimit:" + key},
+		rps, burst, ttl.Milliseconds(), strconv.FormatFloat(now, 'f', 6, 64)).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	if allowed, _ := res[0].(int64); allowed == 1 {
+		return true, 0, nil
+	}
+	tokens, _ := strconv.ParseFloat(res[1].(string), 64)
+
+	return false, time.Duration((1 - tokens) / rps * float64(time.Second)), nil
+}
+
+func (rd *storageRedis) Ban(key string, d time.Duration) error {
+	return rd.client.Set(context.Background(), "ratelimit:"+key, 1, d).Err()
+}
+
+func (rd *storageRedis) BannedFor(key string) (time.Duration, error) {
+	ttl, err := rd.client.PTTL(context.Background(), "ratelimit:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// -2 when the key does not exist
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
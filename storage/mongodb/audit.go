@@ -0,0 +1,84 @@
+package mongodb
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEvent records an action on an account which has to be explained later, it outlives the account
+type AuditEvent struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Action    string             `bson:"action"`
+	UserID    string             `bson:"user_id"`
+	Username  string             `bson:"username"`
+	Actor     string             `bson:"actor"` // username of who did it, the user or an admin
+	IPAddress string             `bson:"ip_address"`
+	Details   string             `bson:"details,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+const (
+	AuditAccountDeleted = "account_deleted"
+	AuditDataExported   = "data_exported"
+	AuditPlanChanged    = "plan_changed"
+)
+
+type auditRepo struct {
+	col *mongo.Collection
+}
+
+type AuditI interface {
+	AddEvent(c context.Context, event *AuditEvent) error
+	GetEvents(c context.Context, search string, page, limit int64) ([]AuditEvent, int64, error)
+}
+
+func NewAudit(db *mongo.Database) AuditI {
+	return &auditRepo{
+		col: db.Collection("audit_log"),
+	}
+}
+
+func (a *auditRepo) AddEvent(c context.Context, event *AuditEvent) error {
+	event.ID = primitive.NewObjectID()
+	event.CreatedAt = time.Now()
+
+	_, err := a.col.InsertOne(c, event)
+	return err
+}
+
+// GetEvents returns page of events, newest first, search matches user id, action or the beginning of username
+func (a *auditRepo) GetEvents(c context.Context, search string, page, limit int64) ([]AuditEvent, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		filter["$or"] = bson.A{
+			bson.M{"user_id": search},
+			bson.M{"action": search},
+			bson.M{"username": bson.M{"$regex": "^" + regexp.QuoteMeta(search), "$options": "i"}},
+		}
+	}
+
+	count, err := a.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cur, err := a.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(c)
+
+	events := make([]AuditEvent, 0)
+	if err := cur.All(c, &events); err != nil {
+		return nil, 0, err
+	}
+
+	return events, count, nil
+}
@@ -0,0 +1,107 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ban blocks an ip address, ssh key fingerprint or subdomain from using the service
+type Ban struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Kind      string             `bson:"kind"`
+	Value     string             `bson:"value"`
+	Reason    string             `bson:"reason"`
+	CreatedBy string             `bson:"created_by"`
+	CreatedAt string             `bson:"created_at"`
+}
+
+const (
+	BanIP          = "ip"
+	BanFingerprint = "fingerprint"
+	BanSubdomain   = "subdomain"
+)
+
+type banRepo struct {
+	col *mongo.Collection
+}
+
+type BanI interface {
+	AddBan(c context.Context, b *Ban) error
+	DeleteBan(c context.Context, id string) error
+	IsBanned(c context.Context, kind, value string) (bool, error)
+	GetBans(c context.Context, search string, page, limit int64) ([]Ban, int64, error)
+}
+
+func NewBan(db *mongo.Database) BanI {
+	return &banRepo{
+		col: db.Collection("bans"),
+	}
+}
+
+func (b *banRepo) AddBan(c context.Context, ban *Ban) error {
+	ban.ID = primitive.NewObjectID()
+	ban.CreatedAt = time.Now().Format(time.RFC3339)
+
+	_, err := b.col.UpdateOne(c, bson.M{"kind": ban.Kind, "value": ban.Value}, bson.M{"$setOnInsert": ban}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (b *banRepo) DeleteBan(c context.Context, id string) error {
+	ID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.col.DeleteOne(c, bson.M{"_id": ID})
+	return err
+}
+
+func (b *banRepo) IsBanned(c context.Context, kind, value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+
+	err := b.col.FindOne(c, bson.M{"kind": kind, "value": value}).Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetBans returns page of bans, newest first, search matches the beginning of banned value
+func (b *banRepo) GetBans(c context.Context, search string, page, limit int64) ([]Ban, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		filter["value"] = bson.M{"$regex": "^" + regexp.QuoteMeta(search)}
+	}
+
+	count, err := b.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cur, err := b.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(c)
+
+	bans := make([]Ban, 0)
+	if err := cur.All(c, &bans); err != nil {
+		return nil, 0, err
+	}
+
+	return bans, count, nil
+}
@@ -0,0 +1,70 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type CustomDomain struct {
+	Domain     string `bson:"domain"`
+	Token      string `bson:"token"`
+	Verified   bool   `bson:"verified"`
+	Method     string `bson:"method"` // dns or http
+	CreatedAt  string `bson:"created_at"`
+	VerifiedAt string `bson:"verified_at"`
+}
+
+func (u *userRepo) SetCustomDomain(c context.Context, id string, domain *CustomDomain) error {
+	ID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.col.UpdateOne(c, bson.M{"_id": ID}, bson.M{"$set": bson.M{"custom_domain": domain}})
+
+	return err
+}
+
+func (u *userRepo) VerifyCustomDomain(c context.Context, id, method, verifiedAt string) error {
+	ID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.col.UpdateOne(c, bson.M{"_id": ID}, bson.M{"$set": bson.M{
+		"custom_domain.verified":    true,
+		"custom_domain.method":      method,
+		"custom_domain.verified_at": verifiedAt,
+	}})
+
+	return err
+}
+
+func (u *userRepo) DeleteCustomDomain(c context.Context, id string) error {
+	ID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.col.UpdateOne(c, bson.M{"_id": ID}, bson.M{"$unset": bson.M{"custom_domain": ""}})
+
+	return err
+}
+
+// FindUserByCustomDomain finds the owner of verified custom domain
+func (u *userRepo) FindUserByCustomDomain(c context.Context, domain string) (*User, error) {
+	var user User
+	err := u.col.FindOne(c, bson.M{"custom_domain.domain": domain, "custom_domain.verified": true}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, mongo.ErrNoDocuments
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,141 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Identity links an account at a login provider to a user. It is found by provider and subject,
+// the stable id of the provider, usernames and emails can change and are kept only to be shown.
+type Identity struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Provider   string             `bson:"provider"`
+	Subject    string             `bson:"subject"`
+	UserID     string             `bson:"user_id"`
+	Username   string             `bson:"username"`
+	Email      string             `bson:"email,omitempty"`
+	CreatedAt  string             `bson:"created_at"`
+	LastUsedAt string             `bson:"last_used_at,omitempty"`
+}
+
+// ErrIdentityLinked is returned when the provider account already belongs to a user
+var ErrIdentityLinked = errors.New("identity is already linked to an account")
+
+type identityRepo struct {
+	col *mongo.Collection
+}
+
+type IdentityI interface {
+	CreateIndexes(c context.Context) error
+	CreateIdentity(c context.Context, identity *Identity) error
+	FindIdentity(c context.Context, provider, subject string) (*Identity, error)
+	TouchIdentity(c context.Context, id primitive.ObjectID, username, email string) error
+	GetIdentitiesByUserID(c context.Context, userID string) ([]Identity, error)
+	FindIdentitiesByProvider(c context.Context, provider string) ([]Identity, error)
+	DeleteIdentity(c context.Context, userID, id string) error
+	DeleteIdentitiesByUserID(c context.Context, userID string) error
+}
+
+func NewIdentity(db *mongo.Database) IdentityI {
+	return &identityRepo{
+		col: db.Collection("identities"),
+	}
+}
+
+// CreateIndexes makes one provider account linkable to only one user
+func (i *identityRepo) CreateIndexes(c context.Context) error {
+	_, err := i.col.Indexes().CreateMany(c, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.M{"user_id": 1},
+		},
+	})
+	return err
+}
+
+func (i *identityRepo) CreateIdentity(c context.Context, identity *Identity) error {
+	identity.ID = primitive.NewObjectID()
+	identity.CreatedAt = time.Now().Format(time.RFC3339)
+	identity.LastUsedAt = identity.CreatedAt
+
+	_, err := i.col.InsertOne(c, identity)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+func (i *identityRepo) FindIdentity(c context.Context, provider, subject string) (*Identity, error) {
+	var identity Identity
+	err := i.col.FindOne(c, bson.M{"provider": provider, "subject": subject}).Decode(&identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// TouchIdentity keeps username and email of the provider account up to date on login
+func (i *identityRepo) TouchIdentity(c context.Context, id primitive.ObjectID, username, email string) error {
+	_, err := i.col.UpdateOne(c, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"username":     username,
+		"email":        email,
+		"last_used_at": time.Now().Format(time.RFC3339),
+	}})
+	return err
+}
+
+func (i *identityRepo) GetIdentitiesByUserID(c context.Context, userID string) ([]Identity, error) {
+	cur, err := i.col.Find(c, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(c)
+
+	identities := make([]Identity, 0)
+	if err := cur.All(c, &identities); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+func (i *identityRepo) FindIdentitiesByProvider(c context.Context, provider string) ([]Identity, error) {
+	cur, err := i.col.Find(c, bson.M{"provider": provider})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(c)
+
+	identities := make([]Identity, 0)
+	if err := cur.All(c, &identities); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// DeleteIdentity unlinks the identity of the user, identities of other users are not touched
+func (i *identityRepo) DeleteIdentity(c context.Context, userID, id string) error {
+	ID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = i.col.DeleteOne(c, bson.M{"_id": ID, "user_id": userID})
+	return err
+}
+
+func (i *identityRepo) DeleteIdentitiesByUserID(c context.Context, userID string) error {
+	_, err := i.col.DeleteMany(c, bson.M{"user_id": userID})
+	return err
+}
@@ -0,0 +1,64 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OAuthState is kept between sending the user to the login provider and the callback
+type OAuthState struct {
+	Key       string    `bson:"_id"`
+	Provider  string    `bson:"provider"`
+	Nonce     string    `bson:"nonce"`
+	Verifier  string    `bson:"verifier"`
+	UserID    string    `bson:"user_id,omitempty"` // signed in user which links the provider or confirms an action
+	Action    string    `bson:"action,omitempty"`
+	CreatedAt time.Time `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+type oauthStateRepo struct {
+	col *mongo.Collection
+}
+
+type OAuthStateI interface {
+	CreateIndexes(c context.Context) error
+	SaveState(c context.Context, state *OAuthState) error
+	TakeState(c context.Context, key string) (*OAuthState, error)
+}
+
+func NewOAuthState(db *mongo.Database) OAuthStateI {
+	return &oauthStateRepo{
+		col: db.Collection("oauth_states"),
+	}
+}
+
+// CreateIndexes lets mongodb remove abandoned states once they expire
+func (o *oauthStateRepo) CreateIndexes(c context.Context) error {
+	_, err := o.col.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (o *oauthStateRepo) SaveState(c context.Context, state *OAuthState) error {
+	_, err := o.col.InsertOne(c, state)
+	return err
+}
+
+// TakeState returns the state and deletes it, so every state is used only once.
+// TTL monitor runs once a minute, expired states which are still there are not returned.
+func (o *oauthStateRepo) TakeState(c context.Context, key string) (*OAuthState, error) {
+	var state OAuthState
+	err := o.col.FindOneAndDelete(c, bson.M{"_id": key, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
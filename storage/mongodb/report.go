@@ -0,0 +1,125 @@
+package mongodb
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Report flags a link for admins, the sender is copied from the link so it can be banned after the link is gone
+type Report struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Link        string             `bson:"link"`
+	Reason      string             `bson:"reason"`
+	Details     string             `bson:"details,omitempty"`
+	ReporterIP  string             `bson:"reporter_ip"`
+	Subdomain   string             `bson:"subdomain,omitempty"`
+	Fingerprint string             `bson:"fingerprint"`
+	IPAddress   string             `bson:"ip_address"` // of the sender
+	Filename    string             `bson:"filename,omitempty"`
+	Status      string             `bson:"status"`
+	ReviewedBy  string             `bson:"reviewed_by,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	ReviewedAt  time.Time          `bson:"reviewed_at,omitempty"`
+}
+
+const (
+	ReportOpen     = "open"
+	ReportRestored = "restored"
+	ReportDeleted  = "deleted"
+	ReportBanned   = "banned"
+)
+
+type reportRepo struct {
+	col *mongo.Collection
+}
+
+type ReportI interface {
+	CreateReport(c context.Context, r *Report) error
+	FindReport(c context.Context, id string) (*Report, error)
+	// ResolveReports closes open reports of the link with the status
+	ResolveReports(c context.Context, link, status, admin string) error
+	GetReports(c context.Context, status, search string, page, limit int64) ([]Report, int64, error)
+}
+
+func NewReport(db *mongo.Database) ReportI {
+	return &reportRepo{
+		col: db.Collection("reports"),
+	}
+}
+
+func (r *reportRepo) CreateReport(c context.Context, report *Report) error {
+	report.ID = primitive.NewObjectID()
+	report.Status = ReportOpen
+	report.CreatedAt = time.Now()
+
+	_, err := r.col.InsertOne(c, report)
+	return err
+}
+
+func (r *reportRepo) FindReport(c context.Context, id string) (*Report, error) {
+	ID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+	if err := r.col.FindOne(c, bson.M{"_id": ID}).Decode(&report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+func (r *reportRepo) ResolveReports(c context.Context, link, status, admin string) error {
+	_, err := r.col.UpdateMany(c,
+		bson.M{"link": link, "status": ReportOpen},
+		bson.M{"$set": bson.M{"status": status, "reviewed_by": admin, "reviewed_at": time.Now()}},
+	)
+	return err
+}
+
+// GetReports returns page of reports with the status, oldest first so the queue is worked in order.
+// Search matches link, subdomain, fingerprint or the beginning of ip address of the sender.
+func (r *reportRepo) GetReports(c context.Context, status, search string, page, limit int64) ([]Report, int64, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	if search != "" {
+		filter["$or"] = bson.A{
+			bson.M{"link": search},
+			bson.M{"subdomain": search},
+			bson.M{"fingerprint": search},
+			bson.M{"ip_address": bson.M{"$regex": "^" + regexp.QuoteMeta(search)}},
+		}
+	}
+
+	count, err := r.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort := 1
+	if status != ReportOpen {
+		sort = -1
+	}
+	opts := options.Find().SetSort(bson.M{"_id": sort}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cur, err := r.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(c)
+
+	reports := make([]Report, 0)
+	if err := cur.All(c, &reports); err != nil {
+		return nil, 0, err
+	}
+
+	return reports, count, nil
+}
@@ -1,6 +1,8 @@
-package handlers
+package mongodb
 
-var engshlishNotAllowedSubdomains = []string{
+// defaultReservedSubdomains are seeded into reserved subdomains when the collection is empty,
+// after that the list is managed by admins
+var defaultReservedSubdomains = []string{
 	"direct",
 	"unknown",
 	"delete",
@@ -3,11 +3,13 @@ package mongodb
 import (
 	"context"
 	"errors"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Session struct {
@@ -30,6 +32,9 @@ type SessionI interface {
 	GetSessionByID(c context.Context, sessionID string) (*Session, error)
 	DeleteSessionByID(c context.Context, sessionID string) error
 	GetAllSessions(c context.Context) ([]Session, error)
+	GetSessions(c context.Context, search string, page, limit int64) ([]Session, int64, error)
+	GetSessionsByUserID(c context.Context, userID string) ([]Session, error)
+	DeleteSessionsByUserID(c context.Context, userID, keepSessionID string) error
 }
 
 func NewSession(db *mongo.Database) SessionI {
@@ -56,7 +61,7 @@ func (ses *session) CreateSession(c context.Context, s *Session) (string, error)
 		return res.InsertedID.(primitive.ObjectID).Hex(), nil
 	}
 
-	_, err = ses.col.UpdateOne(c, bson.M{"_id": isExist.SessionID}, bson.M{"$set": bson.M{"access_token": s.AccessToken}, "last_login": time.Now().In(timezone).Format(time.RFC1123)})
+	_, err = ses.col.UpdateOne(c, bson.M{"_id": isExist.SessionID}, bson.M{"$set": bson.M{"access_token": s.AccessToken, "last_login": time.Now().In(timezone).Format(time.RFC1123)}})
 
 	return isExist.SessionID.Hex(), err
 }
@@ -124,3 +129,63 @@ func (u *session) GetAllSessions(c context.Context) ([]Session, error) {
 	}
 	return sessions, nil
 }
+
+// GetSessions returns page of sessions, search matches user id exactly or the beginning of ip address
+func (ses *session) GetSessions(c context.Context, search string, page, limit int64) ([]Session, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		filter["$or"] = bson.A{
+			bson.M{"user_id": search},
+			bson.M{"ip_address": bson.M{"$regex": "^" + regexp.QuoteMeta(search)}},
+		}
+	}
+
+	count, err := ses.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cur, err := ses.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(c)
+
+	sessions := make([]Session, 0)
+	if err := cur.All(c, &sessions); err != nil {
+		return nil, 0, err
+	}
+
+	return sessions, count, nil
+}
+
+func (ses *session) GetSessionsByUserID(c context.Context, userID string) ([]Session, error) {
+	cur, err := ses.col.Find(c, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(c)
+
+	sessions := make([]Session, 0)
+	if err := cur.All(c, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSessionsByUserID signs the user out on all devices, the session with keepSessionID stays when it is not empty
+func (ses *session) DeleteSessionsByUserID(c context.Context, userID, keepSessionID string) error {
+	filter := bson.M{"user_id": userID}
+	if keepSessionID != "" {
+		id, err := primitive.ObjectIDFromHex(keepSessionID)
+		if err != nil {
+			return err
+		}
+		filter["_id"] = bson.M{"$ne": id}
+	}
+
+	_, err := ses.col.DeleteMany(c, filter)
+	return err
+}
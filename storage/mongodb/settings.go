@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"log"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,10 +12,36 @@ import (
 )
 
 type Keys struct {
-	ID        primitive.ObjectID `bson:"_id"`
-	Name      string             `bson:"name"`
-	SSHHash   string             `bson:"ssh_hash"`
-	CreatedAt string             `bson:"created_at"`
+	ID         primitive.ObjectID `bson:"_id"`
+	Name       string             `bson:"name"`
+	SSHHash    string             `bson:"ssh_hash"`
+	CreatedAt  string             `bson:"created_at"`
+	ExpiresAt  string             `bson:"expires_at,omitempty"` // key is not accepted by ssh server after this time
+	LastUsedAt string             `bson:"last_used_at,omitempty"`
+	LastUsedIP string             `bson:"last_used_ip,omitempty"`
+	Defaults   *KeyDefaults       `bson:"defaults,omitempty"`
+	Source     string             `bson:"source,omitempty"` // empty for keys added by hand
+}
+
+// KeySourceGithub marks keys which are synced from GitHub profile of the user
+const KeySourceGithub = "github"
+
+// KeyDefaults are upload options applied when the uploader omits them
+type KeyDefaults struct {
+	From            string `bson:"from,omitempty"`
+	Save            int    `bson:"save,omitempty"`      // minutes the link is valid, like "t="
+	Downloads       int    `bson:"downloads,omitempty"` // how many times the file can be downloaded, like "n="
+	RequirePassword bool   `bson:"require_password,omitempty"`
+}
+
+// Expired tells if the key is past its expiry date
+func (k *Keys) Expired(now time.Time) bool {
+	if k.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, k.ExpiresAt)
+
+	return err == nil && now.After(expiresAt)
 }
 
 func (u *userRepo) SetSubdomainAndSShKey(c context.Context, id, subdomain string, key *Keys) error {
@@ -28,6 +55,28 @@ func (u *userRepo) SetSubdomainAndSShKey(c context.Context, id, subdomain string
 	return err
 }
 
+func (u *userRepo) ChangeSubdomain(c context.Context, id, subdomain string) error {
+	ID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.col.UpdateOne(c, bson.M{"_id": ID}, bson.M{"$set": bson.M{"subdomain": subdomain, "subdomain_changed_at": time.Now().Format(time.RFC3339)}})
+
+	return err
+}
+
+func (u *userRepo) ReleaseSubdomain(c context.Context, id string) error {
+	ID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.col.UpdateOne(c, bson.M{"_id": ID}, bson.M{"$unset": bson.M{"subdomain": ""}, "$set": bson.M{"subdomain_changed_at": time.Now().Format(time.RFC3339)}})
+
+	return err
+}
+
 func (u *userRepo) PushNewKey(c context.Context, id string, key *Keys) error {
 	ID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -52,6 +101,69 @@ func (u *userRepo) DeleteKey(c context.Context, id string) error {
 	return err
 }
 
+// UpdateKey changes name, expiry and upload defaults of the key which belongs to the user
+func (u *userRepo) UpdateKey(c context.Context, userID string, key *Keys) error {
+	ID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	set := bson.M{"keys.$.name": key.Name}
+	unset := bson.M{}
+	if key.ExpiresAt != "" {
+		set["keys.$.expires_at"] = key.ExpiresAt
+	} else {
+		unset["keys.$.expires_at"] = ""
+	}
+	if key.Defaults != nil {
+		set["keys.$.defaults"] = key.Defaults
+	} else {
+		unset["keys.$.defaults"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	res, err := u.col.UpdateOne(c, bson.M{"_id": ID, "keys._id": key.ID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// ReplaceSourceKeys replaces keys which came from the source with the given ones, keys added by hand stay
+func (u *userRepo) ReplaceSourceKeys(c context.Context, userID, source string, keys []Keys) error {
+	ID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.col.UpdateOne(c, bson.M{"_id": ID}, bson.M{"$pull": bson.M{"keys": bson.M{"source": source}}})
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	_, err = u.col.UpdateOne(c, bson.M{"_id": ID}, bson.M{"$push": bson.M{"keys": bson.M{"$each": keys}}})
+
+	return err
+}
+
+// TouchKey remembers when and from where the key was used last time
+func (u *userRepo) TouchKey(c context.Context, fingerprint, ip string) error {
+	_, err := u.col.UpdateOne(c,
+		bson.M{"keys.ssh_hash": fingerprint},
+		bson.M{"$set": bson.M{"keys.$.last_used_at": time.Now().Format(time.RFC3339), "keys.$.last_used_ip": ip}},
+	)
+
+	return err
+}
+
 func (u *userRepo) HasTheSameKey(c context.Context, id, str string) bool {
 	ID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
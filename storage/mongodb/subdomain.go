@@ -0,0 +1,179 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reserved is subdomain which can not be claimed by users
+type Reserved struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Name      string             `bson:"name"`
+	Reason    string             `bson:"reason"` // reserved or blocked
+	CreatedBy string             `bson:"created_by"`
+	CreatedAt string             `bson:"created_at"`
+}
+
+// Released is subdomain which was renamed or given up, it is held for its old owner until AvailableAt
+type Released struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Subdomain    string             `bson:"subdomain"`
+	UserID       string             `bson:"user_id"`
+	NewSubdomain string             `bson:"new_subdomain"` // links of renamed subdomain redirect here, empty when released
+	ReleasedAt   time.Time          `bson:"released_at"`
+	AvailableAt  time.Time          `bson:"available_at"`
+}
+
+const (
+	ReservedReasonReserved = "reserved"
+	ReservedReasonBlocked  = "blocked"
+)
+
+type subdomainRepo struct {
+	reserved *mongo.Collection
+	released *mongo.Collection
+}
+
+type SubdomainI interface {
+	SeedReserved(c context.Context) error
+	IsReserved(c context.Context, name string) (bool, error)
+	AddReserved(c context.Context, r *Reserved) error
+	DeleteReserved(c context.Context, name string) error
+	GetReserved(c context.Context, search string, page, limit int64) ([]Reserved, int64, error)
+	Release(c context.Context, r *Released) error
+	GetReleased(c context.Context, subdomain string) (*Released, error)
+	DeleteReleasedByUserID(c context.Context, userID string) error
+}
+
+func NewSubdomain(db *mongo.Database) SubdomainI {
+	return &subdomainRepo{
+		reserved: db.Collection("reserved_subdomains"),
+		released: db.Collection("released_subdomains"),
+	}
+}
+
+// SeedReserved fills reserved subdomains with defaults when the collection is empty
+func (s *subdomainRepo) SeedReserved(c context.Context) error {
+	_, err := s.reserved.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.M{"name": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	count, err := s.reserved.CountDocuments(c, bson.M{})
+	if err != nil || count > 0 {
+		return err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	seen := make(map[string]bool, len(defaultReservedSubdomains))
+	docs := make([]interface{}, 0, len(defaultReservedSubdomains))
+	for _, name := range defaultReservedSubdomains {
+		name = strings.ToLower(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		docs = append(docs, Reserved{
+			ID:        primitive.NewObjectID(),
+			Name:      name,
+			Reason:    ReservedReasonReserved,
+			CreatedBy: "seed",
+			CreatedAt: now,
+		})
+	}
+
+	_, err = s.reserved.InsertMany(c, docs)
+	return err
+}
+
+func (s *subdomainRepo) IsReserved(c context.Context, name string) (bool, error) {
+	err := s.reserved.FindOne(c, bson.M{"name": name}).Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *subdomainRepo) AddReserved(c context.Context, r *Reserved) error {
+	r.ID = primitive.NewObjectID()
+	r.CreatedAt = time.Now().Format(time.RFC3339)
+
+	_, err := s.reserved.UpdateOne(c, bson.M{"name": r.Name}, bson.M{"$setOnInsert": r}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *subdomainRepo) DeleteReserved(c context.Context, name string) error {
+	_, err := s.reserved.DeleteOne(c, bson.M{"name": name})
+	return err
+}
+
+// GetReserved returns page of reserved subdomains, search matches the beginning of the name
+func (s *subdomainRepo) GetReserved(c context.Context, search string, page, limit int64) ([]Reserved, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		filter["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(search)}
+	}
+
+	count, err := s.reserved.CountDocuments(c, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.M{"name": 1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cur, err := s.reserved.Find(c, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(c)
+
+	reserved := make([]Reserved, 0)
+	if err := cur.All(c, &reserved); err != nil {
+		return nil, 0, err
+	}
+
+	return reserved, count, nil
+}
+
+func (s *subdomainRepo) Release(c context.Context, r *Released) error {
+	r.ID = primitive.NewObjectID()
+
+	_, err := s.released.InsertOne(c, r)
+	return err
+}
+
+// GetReleased returns the subdomain if it is still held after rename or release
+func (s *subdomainRepo) GetReleased(c context.Context, subdomain string) (*Released, error) {
+	var res Released
+
+	opts := options.FindOne().SetSort(bson.M{"released_at": -1})
+	err := s.released.FindOne(c, bson.M{"subdomain": subdomain, "available_at": bson.M{"$gt": time.Now()}}, opts).Decode(&res)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, mongo.ErrNoDocuments
+		}
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// DeleteReleasedByUserID frees subdomains held for the user right away
+func (s *subdomainRepo) DeleteReleasedByUserID(c context.Context, userID string) error {
+	_, err := s.released.DeleteMany(c, bson.M{"user_id": userID})
+	return err
+}
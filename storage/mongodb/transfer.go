@@ -0,0 +1,144 @@
+package mongodb
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Transfer is history record of a file sent through ssh
+type Transfer struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Link        string             `bson:"link"`
+	UserID      string             `bson:"user_id,omitempty"`
+	Subdomain   string             `bson:"subdomain,omitempty"`
+	Fingerprint string             `bson:"fingerprint"`
+	IPAddress   string             `bson:"ip_address"`
+	Filename    string             `bson:"filename,omitempty"`
+	Size        int64              `bson:"size"`
+	SHA256      string             `bson:"sha256,omitempty"`
+	Status      string             `bson:"status"`
+	Threat      string             `bson:"threat,omitempty"` // what the scanner found in quarantined files
+	// QuarantineFile is the name of the kept infected file, empty when it was dropped
+	QuarantineFile string    `bson:"quarantine_file,omitempty"`
+	SentAt         time.Time `bson:"sent_at"`
+	ExpiresAt      time.Time `bson:"expires_at"`
+	FinishedAt     time.Time `bson:"finished_at,omitempty"`
+}
+
+const (
+	TransferActive     = "active"
+	TransferDownloaded = "downloaded"
+	TransferDeleted    = "deleted"
+	TransferExpired    = "expired"
+	TransferKilled     = "killed"
+	// file was not handed out, scanner found a threat or could not check it
+	TransferQuarantined = "quarantined"
+	TransferScanFailed  = "scan_failed"
+)
+
+type transferRepo struct {
+	col *mongo.Collection
+}
+
+type TransferI interface {
+	CreateTransfer(c context.Context, t *Transfer) error
+	FinishTransfer(c context.Context, link, status string) error
+	QuarantineTransfer(c context.Context, link, threat, file string) error
+	GetTransfers(c context.Context, search string, page, limit int64) ([]Transfer, int64, error)
+	GetTransfersByUserID(c context.Context, userID string) ([]Transfer, error)
+	DeleteTransfersByUserID(c context.Context, userID string) error
+}
+
+func NewTransfer(db *mongo.Database) TransferI {
+	return &transferRepo{
+		col: db.Collection("transfers"),
+	}
+}
+
+func (t *transferRepo) CreateTransfer(c context.Context, tr *Transfer) error {
+	tr.ID = primitive.NewObjectID()
+	tr.Status = TransferActive
+
+	_, err := t.col.InsertOne(c, tr)
+	return err
+}
+
+// FinishTransfer sets final status of the active transfer with the link
+func (t *transferRepo) FinishTransfer(c context.Context, link, status string) error {
+	_, err := t.col.UpdateOne(c,
+		bson.M{"link": link, "status": TransferActive},
+		bson.M{"$set": bson.M{"status": status, "finished_at": time.Now()}},
+	)
+	return err
+}
+
+// QuarantineTransfer finishes the active transfer with the link as quarantined and keeps what was found and where
+// the file is kept
+func (t *transferRepo) QuarantineTransfer(c context.Context, link, threat, file string) error {
+	_, err := t.col.UpdateOne(c,
+		bson.M{"link": link, "status": TransferActive},
+		bson.M{"$set": bson.M{"status": TransferQuarantined, "threat": threat, "quarantine_file": file, "finished_at": time.Now()}},
+	)
+	return err
+}
+
+// GetTransfers returns page of transfers, newest first, search matches link, subdomain, fingerprint, sha256 checksum
+// or the beginning of ip address
+func (t *transferRepo) GetTransfers(c context.Context, search string, page, limit int64) ([]Transfer, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		filter["$or"] = bson.A{
+			bson.M{"link": search},
+			bson.M{"subdomain": search},
+			bson.M{"fingerprint": search},
+			bson.M{"sha256": strings.ToLower(search)},
+			bson.M{"ip_address": bson.M{"$regex": "^" + regexp.QuoteMeta(search)}},
+		}
+	}
+
+	count, err := t.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.M{"sent_at": -1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cur, err := t.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(c)
+
+	transfers := make([]Transfer, 0)
+	if err := cur.All(c, &transfers); err != nil {
+		return nil, 0, err
+	}
+
+	return transfers, count, nil
+}
+
+func (t *transferRepo) GetTransfersByUserID(c context.Context, userID string) ([]Transfer, error) {
+	cur, err := t.col.Find(c, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"sent_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(c)
+
+	transfers := make([]Transfer, 0)
+	if err := cur.All(c, &transfers); err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+func (t *transferRepo) DeleteTransfersByUserID(c context.Context, userID string) error {
+	_, err := t.col.DeleteMany(c, bson.M{"user_id": userID})
+	return err
+}
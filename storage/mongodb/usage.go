@@ -3,25 +3,34 @@ package mongodb
 import (
 	"context"
 	"errors"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type usageRepo struct {
 	col *mongo.Collection
 }
 
+// Usage counts transfers and bytes of a sender (ip address, ssh key or user) in a period like a day or a month
 type Usage struct {
-	ID        primitive.ObjectID `bson:"_id"`
-	IPAddress string             `bson:"ip_address"`
-	Usage     int                `bson:"usage"`
+	ID        string    `bson:"_id"`
+	Subject   string    `bson:"subject"`
+	Period    string    `bson:"period"`
+	Transfers int64     `bson:"transfers"`
+	Bytes     int64     `bson:"bytes"`
+	Rejected  int64     `bson:"rejected"` // uploads which were stopped for breaking limits
+	ExpiresAt time.Time `bson:"expires_at"`
 }
 
 type UsageStorageI interface {
-	GetUsage(ctx context.Context, ipAddr string) (*Usage, error)
-	CreateUsage(ctx context.Context, usage *Usage) (string, error)
+	CreateIndexes(ctx context.Context) error
+	// GetUsage returns counters of the subject in the period, zeros when nothing was sent
+	GetUsage(ctx context.Context, subject, period string) (transfers, bytes, rejected int64, err error)
+	// AddUsage adds to counters of the subject in the period, they are removed after expiresAt
+	AddUsage(ctx context.Context, subject, period string, transfers, bytes, rejected int64, expiresAt time.Time) error
 }
 
 func NewUsage(db *mongo.Database) UsageStorageI {
@@ -30,33 +39,45 @@ func NewUsage(db *mongo.Database) UsageStorageI {
 	}
 }
 
-func (u *usageRepo) GetUsage(ctx context.Context, ipAddr string) (*Usage, error) {
+// CreateIndexes lets mongodb remove counters of past periods
+func (u *usageRepo) CreateIndexes(ctx context.Context) error {
+	_, err := u.col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (u *usageRepo) GetUsage(ctx context.Context, subject, period string) (int64, int64, int64, error) {
 	var res Usage
 
-	err := u.col.FindOne(ctx, bson.M{"ip_address": ipAddr}).Decode(&res)
+	err := u.col.FindOne(ctx, bson.M{"_id": usageID(subject, period)}).Decode(&res)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, mongo.ErrNoDocuments
+			return 0, 0, 0, nil
 		}
-		return nil, err
+		return 0, 0, 0, err
 	}
 
-	return &res, nil
+	return res.Transfers, res.Bytes, res.Rejected, nil
 }
 
-func (u *usageRepo) CreateUsage(ctx context.Context, usage *Usage) (string, error) {
-
-	use, err := u.GetUsage(context.Background(), usage.IPAddress)
-	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
-		return "", err
-	}
-
-	if use == nil {
-		usage.ID = primitive.NewObjectID()
-		res, err := u.col.InsertOne(ctx, usage)
-		return res.InsertedID.(primitive.ObjectID).Hex(), err
-	}
+func (u *usageRepo) AddUsage(ctx context.Context, subject, period string, transfers, bytes, rejected int64, expiresAt time.Time) error {
+	_, err := u.col.UpdateOne(ctx,
+		bson.M{"_id": usageID(subject, period)},
+		bson.M{
+			"$inc": bson.M{"transfers": transfers, "bytes": bytes, "rejected": rejected},
+			"$setOnInsert": bson.M{
+				"subject":    subject,
+				"period":     period,
+				"expires_at": expiresAt,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
 
-	_, err = u.col.UpdateOne(ctx, bson.M{"ip_address": usage.IPAddress}, bson.M{"$inc": bson.M{"usage": 1}})
-	return "", err
+func usageID(subject, period string) string {
+	return subject + "|" + period
 }
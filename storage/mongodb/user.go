@@ -3,10 +3,12 @@ package mongodb
 import (
 	"context"
 	"errors"
+	"regexp"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type User struct {
@@ -15,11 +17,18 @@ type User struct {
 	Fullname               string             `bson:"fullname"`
 	Email                  *string            `bson:"email"`
 	Subdomain              *string            `bson:"subdomain"`
+	SubdomainChangedAt     string             `bson:"subdomain_changed_at,omitempty"`
 	CreatedAt              string             `bson:"created_at"`
 	LogInAndSignUpProvider string             `bson:"provider"` // github or google
 	Keys                   []Keys             `bson:"keys"`
+	CustomDomain           *CustomDomain      `bson:"custom_domain,omitempty"`
+	Role                   string             `bson:"role,omitempty"`
+	Plan                   string             `bson:"plan,omitempty"` // quota plan set by admins, empty for the default one
 }
 
+// RoleAdmin gives access to the admin console
+const RoleAdmin = "admin"
+
 type userRepo struct {
 	col *mongo.Collection
 }
@@ -27,17 +36,30 @@ type userRepo struct {
 type UserI interface {
 	RegisterUserFirst(c context.Context, user *User) (interface{}, error)
 	FindUserByUsername(c context.Context, username string) (*User, error)
-	FindUserByUsernameForLS(c context.Context, username, provider string) (*User, error)
+	FindLegacyUserByEmail(c context.Context, email, provider string) (*User, error)
 	FindUserByEmail(c context.Context, email string) (*User, error)
 	FindUserBySubdomain(c context.Context, subdomain string) (*User, error)
 	SetSubdomainAndSShKey(c context.Context, id, subdomain string, key *Keys) error
+	ChangeSubdomain(c context.Context, id, subdomain string) error
+	ReleaseSubdomain(c context.Context, id string) error
 	PushNewKey(c context.Context, id string, key *Keys) error
 	DeleteKey(c context.Context, id string) error
+	UpdateKey(c context.Context, userID string, key *Keys) error
+	TouchKey(c context.Context, fingerprint, ip string) error
+	ReplaceSourceKeys(c context.Context, userID, source string, keys []Keys) error
 	HasTheSameKey(c context.Context, id, str string) bool
 	FindUserByID(c context.Context, id string) (*User, error)
 	GetUserInfoBySSH(c context.Context, str string) (*User, error)
 	GetUserInfoByHashSSH(c context.Context, str string) (*User, error)
 	GetAllUsers(c context.Context) ([]User, error)
+	GetUsers(c context.Context, search string, page, limit int64) ([]User, int64, error)
+	SyncAdmins(c context.Context, ids []string) error
+	SetPlan(c context.Context, id, plan string) error
+	DeleteUser(c context.Context, id string) error
+	SetCustomDomain(c context.Context, id string, domain *CustomDomain) error
+	VerifyCustomDomain(c context.Context, id, method, verifiedAt string) error
+	DeleteCustomDomain(c context.Context, id string) error
+	FindUserByCustomDomain(c context.Context, domain string) (*User, error)
 }
 
 func NewUser(db *mongo.Database) UserI {
@@ -91,10 +113,11 @@ func (u *userRepo) FindUserByID(c context.Context, id string) (*User, error) {
 	return &res, nil
 }
 
-func (u *userRepo) FindUserByUsernameForLS(c context.Context, username, provider string) (*User, error) {
+// FindLegacyUserByEmail finds the user which signed up with the provider and the email
+func (u *userRepo) FindLegacyUserByEmail(c context.Context, email, provider string) (*User, error) {
 	var res User
 
-	err := u.col.FindOne(c, bson.M{"username": username, "provider": provider}).Decode(&res)
+	err := u.col.FindOne(c, bson.M{"email": email, "provider": provider}).Decode(&res)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 
@@ -160,3 +183,86 @@ func (u *userRepo) GetAllUsers(c context.Context) ([]User, error) {
 	}
 	return users, nil
 }
+
+// GetUsers returns page of users, search matches the beginning of username, subdomain or email
+func (u *userRepo) GetUsers(c context.Context, search string, page, limit int64) ([]User, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		prefix := bson.M{"$regex": "^" + regexp.QuoteMeta(search), "$options": "i"}
+		filter["$or"] = bson.A{
+			bson.M{"username": prefix},
+			bson.M{"subdomain": prefix},
+			bson.M{"email": prefix},
+		}
+	}
+
+	count, err := u.col.CountDocuments(c, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cur, err := u.col.Find(c, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(c)
+
+	users := make([]User, 0)
+	if err := cur.All(c, &users); err != nil {
+		return nil, 0, err
+	}
+
+	return users, count, nil
+}
+
+// SyncAdmins gives admin role to the users with the ids and takes it from everyone else
+func (u *userRepo) SyncAdmins(c context.Context, ids []string) error {
+	IDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		ID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return err
+		}
+		IDs = append(IDs, ID)
+	}
+
+	_, err := u.col.UpdateMany(c,
+		bson.M{"role": RoleAdmin, "_id": bson.M{"$nin": IDs}},
+		bson.M{"$unset": bson.M{"role": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	if len(IDs) == 0 {
+		return nil
+	}
+	_, err = u.col.UpdateMany(c, bson.M{"_id": bson.M{"$in": IDs}}, bson.M{"$set": bson.M{"role": RoleAdmin}})
+	return err
+}
+
+// SetPlan assigns the quota plan to the user, empty plan returns the user to the default one
+func (u *userRepo) SetPlan(c context.Context, id, plan string) error {
+	ID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{"plan": plan}}
+	if plan == "" {
+		update = bson.M{"$unset": bson.M{"plan": ""}}
+	}
+	_, err = u.col.UpdateOne(c, bson.M{"_id": ID}, update)
+	return err
+}
+
+// DeleteUser removes the user with keys, subdomain and custom domain of the user
+func (u *userRepo) DeleteUser(c context.Context, id string) error {
+	ID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.col.DeleteOne(c, bson.M{"_id": ID})
+	return err
+}
@@ -9,19 +9,40 @@ type StorageI interface {
 	User() mongodb.UserI
 	Session() mongodb.SessionI
 	Usage() mongodb.UsageStorageI
+	Subdomain() mongodb.SubdomainI
+	Transfer() mongodb.TransferI
+	Ban() mongodb.BanI
+	Identity() mongodb.IdentityI
+	OAuthState() mongodb.OAuthStateI
+	Audit() mongodb.AuditI
+	Report() mongodb.ReportI
 }
 
 type StoragePg struct {
-	userRepo    mongodb.UserI
-	sessionRepo mongodb.SessionI
-	usageRepo   mongodb.UsageStorageI
+	userRepo      mongodb.UserI
+	sessionRepo   mongodb.SessionI
+	usageRepo     mongodb.UsageStorageI
+	subdomainRepo mongodb.SubdomainI
+	transferRepo  mongodb.TransferI
+	banRepo       mongodb.BanI
+	identityRepo  mongodb.IdentityI
+	stateRepo     mongodb.OAuthStateI
+	auditRepo     mongodb.AuditI
+	reportRepo    mongodb.ReportI
 }
 
 func NewStorage(db *mongo.Database) StorageI {
 	return &StoragePg{
-		userRepo:    mongodb.NewUser(db),
-		sessionRepo: mongodb.NewSession(db),
-		usageRepo:   mongodb.NewUsage(db),
+		userRepo:      mongodb.NewUser(db),
+		sessionRepo:   mongodb.NewSession(db),
+		usageRepo:     mongodb.NewUsage(db),
+		subdomainRepo: mongodb.NewSubdomain(db),
+		transferRepo:  mongodb.NewTransfer(db),
+		banRepo:       mongodb.NewBan(db),
+		identityRepo:  mongodb.NewIdentity(db),
+		stateRepo:     mongodb.NewOAuthState(db),
+		auditRepo:     mongodb.NewAudit(db),
+		reportRepo:    mongodb.NewReport(db),
 	}
 }
 
@@ -36,3 +57,31 @@ func (s *StoragePg) Session() mongodb.SessionI {
 func (s *StoragePg) Usage() mongodb.UsageStorageI {
 	return s.usageRepo
 }
+
+func (s *StoragePg) Subdomain() mongodb.SubdomainI {
+	return s.subdomainRepo
+}
+
+func (s *StoragePg) Transfer() mongodb.TransferI {
+	return s.transferRepo
+}
+
+func (s *StoragePg) Ban() mongodb.BanI {
+	return s.banRepo
+}
+
+func (s *StoragePg) Identity() mongodb.IdentityI {
+	return s.identityRepo
+}
+
+func (s *StoragePg) OAuthState() mongodb.OAuthStateI {
+	return s.stateRepo
+}
+
+func (s *StoragePg) Audit() mongodb.AuditI {
+	return s.auditRepo
+}
+
+func (s *StoragePg) Report() mongodb.ReportI {
+	return s.reportRepo
+}